@@ -3,20 +3,54 @@ import {
   CardContent,
 } from '@/components/ui/card'
 import { Badge } from '@/components/ui/badge'
+import { Button } from '@/components/ui/button'
+import { useMutation } from '@tanstack/react-query'
 
 import {
   Network,
   Earth,
   Activity,
   LockIcon,
+  Stethoscope,
 } from 'lucide-react'
-import { Route, RouterStatus, RouterType } from '../../lib/api'
+import { diagnoseRoute, Route, RouteDiagnostic, RouterStatus, RouterType } from '../../lib/api'
 import { formatDuration } from '@/lib/utils'
 
 type RouteCardProps = {
   route: Route
 }
 
+type RouteStatusCardProps = RouteCardProps & {
+  serviceId?: string
+  index?: number
+}
+
+const DiagnosticDetails = ({ diagnostic }: { diagnostic: RouteDiagnostic }) => {
+  const checks = [
+    ['disco', diagnostic.disco],
+    ['tsmp', diagnostic.tsmp],
+    ['dial', diagnostic.dial],
+  ] as const
+  return (
+    <div className="col-span-10 text-xs text-muted-foreground flex flex-col gap-1">
+      <span className={diagnostic.result === 'ok' ? 'text-green-500' : 'text-red-500'}>
+        {diagnostic.result}: {diagnostic.message}
+      </span>
+      <span>
+        {diagnostic.peer && <>peer {diagnostic.peer} ({diagnostic.peer_ip}) </>}
+        {diagnostic.path && <>via {diagnostic.path === 'derp' ? `DERP ${diagnostic.derp_region}` : `${diagnostic.path} ${diagnostic.endpoint}`}</>}
+      </span>
+      <span className="flex gap-4">
+        {checks.map(([name, check]) => (
+          <span key={name}>
+            {name}: {check.skipped ? 'skipped' : check.ok ? formatDuration(check.latency ?? 0) : check.error}
+          </span>
+        ))}
+      </span>
+    </div>
+  )
+}
+
 const RouteIcon = ({route}:RouteCardProps)=> {
   if (route.type === RouterType.TCP || route.type === RouterType.UDP){
     return <Network />
@@ -24,7 +58,11 @@ const RouteIcon = ({route}:RouteCardProps)=> {
   return route.private? <LockIcon />:<Earth/>
 }
 
-export const RouteStatusCard = ({ route }: RouteCardProps) => {
+export const RouteStatusCard = ({ route, serviceId, index }: RouteStatusCardProps) => {
+  const diagnose = useMutation({
+    mutationFn: () => diagnoseRoute(serviceId!, index!),
+  })
+  const diagnostic = diagnose.data ?? route.diagnostic
   const isActive = (route: Route): boolean => {
     if (route.latency && route?.status === RouterStatus.RUNNING) {
       if (route.latency > -1) {
@@ -45,12 +83,26 @@ export const RouteStatusCard = ({ route }: RouteCardProps) => {
             <a href={`http://${route.domain}`}>http://{route.domain}</a>
           }
         </div>
-        <div className='col-span-3'>{route.machine.address}:{route.machine.port}</div>
+        <div className='col-span-3 flex flex-col'>
+          <span>{route.machine.address}:{route.machine.port}</span>
+          {route.subnet_route && (
+            <span className={`text-xs ${route.subnet_route.online ? 'text-muted-foreground' : 'text-red-500'}`}>
+              {route.subnet_route.peer
+                ? `via ${route.subnet_route.exit_node ? 'exit node' : 'subnet router'} ${route.subnet_route.peer}${route.subnet_route.online ? '' : ' (offline)'}`
+                : 'no tailnet peer advertises this address'}
+            </span>
+          )}
+        </div>
         <div className="col-span-2 flex flex-col gap-1 text-sm text-muted-foreground group">
           <div className="flex gap-2 grow items-center whitespace-nowrap">
             <Activity className={`h-5 w-5 ${isActive(route) ? 'text-green-500' : 'text-red-500'}`} />
             {isActive(route) && formatDuration(route.latency)}              
           </div>
+          {route.tailnet_path?.path && (
+            <span className={`text-xs ${route.tailnet_path.path === 'derp' ? 'text-yellow-600' : 'text-muted-foreground'}`}>
+              {route.tailnet_path.path === 'derp' ? `DERP ${route.tailnet_path.derp_region}` : route.tailnet_path.path}
+            </span>
+          )}
           <span className="hidden group-hover:block">
           {route.type === RouterType.UDP && (
               <span className="text-xs text-muted-foreground">
@@ -67,6 +119,13 @@ export const RouteStatusCard = ({ route }: RouteCardProps) => {
         >
           {route?.status}
         </Badge>
+        {serviceId !== undefined && index !== undefined && route.type !== RouterType.FILES && (
+          <Button variant="ghost" size="icon" title="Diagnose" disabled={diagnose.isPending} onClick={() => diagnose.mutate()}>
+            <Stethoscope className="h-4 w-4" />
+          </Button>
+        )}
+        {diagnostic && <DiagnosticDetails diagnostic={diagnostic} />}
+        {diagnose.isError && <span className="col-span-10 text-xs text-red-500">{diagnose.error.message}</span>}
       </CardContent>
     </Card>
   )
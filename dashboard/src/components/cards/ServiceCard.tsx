@@ -230,9 +230,9 @@ export const ServiceCard = ({ id, edit }: ServiceCardProps) => {
           </Button>}
         </CardHeader>
         <CardContent className='flex flex-col gap-4'>
-          {service.routes.map(route => edit ?
+          {service.routes.map((route, index) => edit ?
             <RouteEditCard route={route} key={route.key} updateRoute={updateRoute} removeRoute={removeRoute} /> :
-            <RouteStatusCard route={route} key={route.key} />)}
+            <RouteStatusCard route={route} key={route.key} serviceId={service.id} index={index} />)}
         </CardContent>
       </Card>
       {!edit && <RouterChart service={service} />}
@@ -17,8 +17,8 @@ import {
   PopoverContent,
   PopoverTrigger,
 } from "@/components/ui/popover"
-import { useQuery } from "@tanstack/react-query"
-import { getTailScaleNodes, Route } from "@/lib/api"
+import { useMutation, useQuery } from "@tanstack/react-query"
+import { getTailScaleNodes, probeTailScaleNode, Route } from "@/lib/api"
 import { Label } from "../ui/label"
 import { Input } from "../ui/input"
 
@@ -35,6 +35,9 @@ export const TailscaleHost = ({ route, updateRoute }: TailscaleHostProps) => {
     queryKey: ['tailscaleNodes'],
     queryFn: getTailScaleNodes,
   })
+  const probe = useMutation({
+    mutationFn: probeTailScaleNode,
+  })
   const options = React.useMemo(() => {
     if (data) {
       let options = data.map((node) => ({
@@ -153,6 +156,29 @@ export const TailscaleHost = ({ route, updateRoute }: TailscaleHostProps) => {
           value={machine?.port}
           onChange={handlePortChange}
         />
+        <div className="flex flex-wrap items-center gap-2 mt-2">
+          <Button
+            type="button"
+            variant="outline"
+            size="sm"
+            disabled={!(machine?.node || machine?.address) || probe.isPending}
+            onClick={() => probe.mutate(machine.node || machine.address)}
+          >
+            Suggest ports
+          </Button>
+          {probe.data?.map((suggestion) => (
+            <Button
+              key={suggestion.port}
+              type="button"
+              variant="secondary"
+              size="sm"
+              onClick={() => updateRoute({ ...route, machine: { ...route.machine, port: suggestion.port } })}
+            >
+              {suggestion.port} {suggestion.service}
+            </Button>
+          ))}
+          {probe.isError && <span className="text-xs text-red-500">{probe.error.message}</span>}
+        </div>
       </div>
     </div>
   )
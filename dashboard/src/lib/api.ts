@@ -5,6 +5,7 @@ export enum RouterStatus {
     STARTING = "Starting",
     RUNNING = "Running",
     STOPPED = "Stopped",
+    DRAINING = "Draining",
 }
 
 export enum RouterType {
@@ -12,6 +13,8 @@ export enum RouterType {
     HTTP = "http",
     TCP = "tcp",
     UDP = "udp",
+    FILES = "files",
+    TLS_PASSTHROUGH = "tls-passthrough",
 }
 
 export enum Role {
@@ -29,13 +32,26 @@ export enum TS_STATE {
 }
 
 export interface TS_STATUS {
+    name: string
     messages: string[]
     version: string
     state: TS_STATE
     nodes: TailsaleNode[]
     hostname: string
     key_expiry: Date | null
+    key_expiry_warning?: boolean
+    ephemeral?: boolean
+    tags?: string[]
     auth_url?: string
+    supervisor?: TailnetState
+}
+
+export interface TailnetState {
+    state: string
+    error?: string
+    attempts?: number
+    since: Date
+    paused: boolean
 }
 
 export interface Config {
@@ -55,6 +71,16 @@ export interface TailsaleNode {
     os: string
     key_expiry: Date
     last_seen: string
+    ips: string[]
+    tags?: string[]
+    exit_node?: boolean
+    exit_node_option?: boolean
+}
+
+export interface ProbePort {
+    port: number
+    service: string
+    type: string
 }
 
 export interface CreateService {
@@ -67,8 +93,50 @@ export interface Service {
     id: string
     name: string
     enabled: boolean
+    tailnet?: string
     routes: Route[]
     latency: number
+    warnings?: string[]
+}
+
+export interface DiagnosticCheck {
+    ok: boolean
+    skipped?: boolean
+    latency?: number
+    error?: string
+}
+
+export interface RouteDiagnostic {
+    target: string
+    peer?: string
+    peer_ip?: string
+    path?: "direct" | "derp" | "peer-relay"
+    endpoint?: string
+    derp_region?: string
+    disco: DiagnosticCheck
+    tsmp: DiagnosticCheck
+    dial: DiagnosticCheck
+    result: "ok" | "peer_unreachable" | "blocked" | "backend_down" | "failed"
+    message: string
+    checked: string
+}
+
+export interface TailnetPath {
+    peer: string
+    path: "direct" | "derp" | "peer-relay" | ""
+    endpoint?: string
+    derp_region?: string
+    active: boolean
+}
+
+export interface SubnetRoute {
+    address: string
+    prefix?: string
+    peer?: string
+    peer_ip?: string
+    online: boolean
+    primary?: boolean
+    exit_node?: boolean
 }
 
 export interface ProxyRule {
@@ -85,14 +153,56 @@ export interface ProxyHeaders {
     set?: Record<string, string>
 }
 
+export interface WebSocketSettings {
+    max_duration?: number
+    ping_interval?: number
+    idle_timeout?: number
+}
+
 export interface ProxySettings {
     timeout?: number
+    max_request_body?: number
+    response_header_timeout?: number
+    idle_timeout?: number
     retry_attempts?: number
     buffer_requests?: boolean
+    streaming?: boolean
+    flush_interval?: number
     preserve_host?: boolean
     follow_redirects?: boolean
     custom_headers?: ProxyHeaders
     rules?: ProxyRule[]
+    websocket?: WebSocketSettings
+}
+
+export interface FileSettings {
+    root?: string
+    archive?: string
+    index_files?: string[]
+    spa?: boolean
+    directory_listing?: boolean
+    cache_max_age?: number
+}
+
+export interface AccessLogConfig {
+    disabled?: boolean
+    sample_rate?: number
+    format?: "combined" | "json" | "template"
+    template?: string
+    separate_file?: boolean
+}
+
+export interface ProxyProtocolSettings {
+    send?: 1 | 2
+    accept?: boolean
+    trusted_proxies?: string[]
+}
+
+export interface TLSSettings {
+    cert_file?: string
+    key_file?: string
+    client_ca_file?: string
+    verify_client?: boolean
 }
 
 export interface Route {
@@ -100,15 +210,74 @@ export interface Route {
     private: boolean
     bot_protect: boolean
     type: string
+    direction?: "forward" | "reverse"
+    expose?: "public" | "funnel" | "public+funnel" | "tailnet"
     domain?: string
     port?: number
+    port_end?: number
+    listen_address?: string
+    ip_version?: "dual" | "ipv4" | "ipv6"
+    drain_timeout?: number
     machine: Machine
     status?: RouterStatus
     latency?: number
+    websockets?: number
+    connections?: number
+    sessions?: number
+    tailnet_url?: string
+    subnet_route?: SubnetRoute
+    diagnostic?: RouteDiagnostic
+    tailnet_path?: TailnetPath
     proxy_settings?: ProxySettings
+    file_settings?: FileSettings
+    access_log?: AccessLogConfig
+    proxy_protocol?: ProxyProtocolSettings
+    tls?: TLSSettings
+    udp?: UDPSettings
+    tailscale_identity?: TailscaleIdentitySettings
+    dialer?: DialerSettings
     stats?: TimeSeries
 }
 
+export interface TailscaleIdentitySettings {
+    headers?: boolean
+    authenticate?: boolean
+    users?: string[]
+}
+
+export interface DialerSettings {
+    type: "tailscale" | "direct" | "socks5" | "http-connect"
+    proxy?: string
+    username?: string
+    password?: string
+    via_tailnet?: boolean
+}
+
+export interface UDPSettings {
+    session_timeout?: number
+    max_sessions?: number
+    buffer_size?: number
+    session_sockets?: boolean
+}
+
+export interface Connection {
+    id: string
+    port: number
+    client: string
+    backend: string
+    started: string
+    sent: number
+    received: number
+    idle: number
+}
+
+export interface RouteConnections {
+    type: RouterType
+    port: number
+    port_end?: number
+    connections: Connection[]
+}
+
 export interface Machine {
     node?: string
     address: string
@@ -118,6 +287,12 @@ export interface Machine {
 export interface Tailsale {
     AuthKey: string
     Hostname: string
+    StateDir?: string
+    Ephemeral?: boolean
+    Tags?: string[]
+    // never returned by the api, leave empty to keep the stored secret
+    ClientSecret?: string
+    KeyExpiryWarning?: number
 }
 
 export interface ProxyStats {
@@ -253,6 +428,37 @@ export const stopService = async (name: string): Promise<Service> => {
     return response.data;
 }
 
+// DIAGNOSE SERVICE ROUTE
+export const diagnoseRoute = async (name: string, route: number): Promise<RouteDiagnostic> => {
+    const response = await axios.post(`${API_URL}/services/${name}/routes/${route}/diagnose`, {}, {
+        headers: getAuth(),
+    });
+    return response.data;
+}
+
+// UPLOAD FILE ROUTE ARCHIVE
+export const uploadArchive = async (name: string, route: number, archive: File): Promise<Service> => {
+    const response = await axios.post(`${API_URL}/services/${name}/routes/${route}/archive`, archive, {
+        headers: { ...getAuth(), "Content-Type": "application/zip" },
+    });
+    return response.data;
+}
+
+// GET SERVICE CONNECTIONS
+export const getConnections = async (name: string): Promise<RouteConnections[]> => {
+    const response = await axios.get(`${API_URL}/services/${name}/connections`, {
+        headers: getAuth(),
+    });
+    return response.data;
+}
+
+// CLOSE CONNECTION
+export const closeConnection = async (name: string, id: string): Promise<void> => {
+    await axios.delete(`${API_URL}/services/${name}/connections/${id}`, {
+        headers: getAuth(),
+    });
+}
+
 // GET TAILSALE CONFIGURATION
 export const getTSConfig = async (): Promise<Tailsale> => {
     const response = await axios.get(`${API_URL}/settings/tailscale`, {
@@ -276,6 +482,13 @@ export const getTSSTATUS = async (): Promise<TS_STATUS> => {
     return response.data;
 }
 
+export const getTailnets = async (): Promise<TS_STATUS[]> => {
+    const response = await axios.get(`${API_URL}/settings/tailnets`, {
+        headers: getAuth(),
+    });
+    return response.data;
+}
+
 // UPDATE TAILSALE CONFIGURATION
 export const updateTSConfig = async (config: Tailsale): Promise<Tailsale> => {
     const response = await axios.post(`${API_URL}/settings/tailscale`, config, {
@@ -330,3 +543,10 @@ export const getTailScaleNodes = async (): Promise<TailsaleNode[]> => {
     });
     return response.data;
 }
+
+export const probeTailScaleNode = async (node: string): Promise<ProbePort[]> => {
+    const response = await axios.get(`${API_URL}/tailsale/nodes/${encodeURIComponent(node)}/ports`, {
+        headers: getAuth(),
+    });
+    return response.data;
+}
@@ -3,7 +3,7 @@ import { Card, CardContent, CardDescription, CardFooter, CardHeader, CardTitle }
 import { Input } from '@/components/ui/input'
 import { Label } from '@/components/ui/label'
 import { useConfig } from '@/context/ConfigContext'
-import { getTSConfig, getTSSTATUS, Tailsale, TailsaleNode, TS_STATE, updateTSConfig } from '@/lib/api'
+import { getTailnets, getTSConfig, getTSSTATUS, Tailsale, TailsaleNode, TS_STATE, updateTSConfig } from '@/lib/api'
 import ProtectedRoute from '@/Protected'
 import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query'
 import { createLazyFileRoute } from '@tanstack/react-router'
@@ -343,6 +343,55 @@ const TailScaleNodes = ({ nodes }: TailScaleNodesProps) => {
   )
 }
 
+const TailnetList = () => {
+  const { data } = useQuery({
+    queryKey: ['tailnets'],
+    queryFn: getTailnets,
+  })
+
+  return (
+    <TabsContent value="tailnets" className="mt-6">
+      <Card>
+        <CardHeader>
+          <CardTitle>Tailnets</CardTitle>
+          <CardDescription>Connection status of every configured tailnet</CardDescription>
+        </CardHeader>
+        <CardContent>
+          <Table>
+            <TableHeader>
+              <TableRow>
+                <TableHead>Name</TableHead>
+                <TableHead>Hostname</TableHead>
+                <TableHead>Status</TableHead>
+                <TableHead>Nodes</TableHead>
+                <TableHead>Error</TableHead>
+              </TableRow>
+            </TableHeader>
+            <TableBody>
+              {(data || []).map((tailnet) => (
+                <TableRow key={tailnet.name}>
+                  <TableCell className="font-medium">{tailnet.name}</TableCell>
+                  <TableCell>{tailnet.hostname}</TableCell>
+                  <TableCell>
+                    <Badge
+                      variant={tailnet.state === TS_STATE.RUNNING ? "default" : "secondary"}
+                      className={tailnet.state === TS_STATE.RUNNING ? "bg-green-500" : "bg-gray-500"}
+                    >
+                      {tailnet.state}
+                    </Badge>
+                  </TableCell>
+                  <TableCell>{tailnet.nodes.length}</TableCell>
+                  <TableCell className="text-muted-foreground">{tailnet.supervisor?.error}</TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        </CardContent>
+      </Card>
+    </TabsContent>
+  )
+}
+
 const SettingComponent = () => {
   const { error, data } = useQuery({
     queryKey: ['settings'],
@@ -411,10 +460,12 @@ const SettingComponent = () => {
       <Tabs value={activeTab} onValueChange={setActiveTab}>
         <TabsList>
           <TabsTrigger value="table">List Nodes</TabsTrigger>
+          <TabsTrigger value="tailnets">Tailnets</TabsTrigger>
           <TabsTrigger value="logs"><TerminalIcon className='h-4' /> Logs</TabsTrigger>
           <TabsTrigger value="status"><CogIcon className='h-4' /> Settings</TabsTrigger>
         </TabsList>
         <TailScaleNodes nodes={data?.nodes || []} />
+        <TailnetList />
         <TailsaleMessages />
         <TailScaleForm />
       </Tabs>
@@ -5,7 +5,7 @@ import (
 	"crypto/tls"
 	"embed"
 	"log"
-	"net/http"
+	"net"
 	"os"
 	"warptail/pkg/api"
 	"warptail/pkg/cmd"
@@ -59,7 +59,6 @@ func ApplicationCmd(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 	router := router.NewRouter()
-	go router.Init(config)
 
 	if utils.IsEmptyStruct(config.Kubernetes) {
 		utils.Logger.Info("Starting Server")
@@ -73,46 +72,56 @@ func ApplicationCmd(ctx context.Context, cmd *cli.Command) error {
 
 func StartK8Router(cfg utils.Config, rt *router.Router) error {
 	defer rt.StopAll()
+	mux := api.NewApi(rt, cfg, ui)
+	rt.Deps.ExposeHandler = mux
+	go rt.Init(cfg)
 	if ctrl, err := controller.NewK8Controller(cfg.Kubernetes); err == nil {
 		rt.Controllers = append(rt.Controllers, ctrl)
 	}
 	go controller.StartController(rt)
-	mux := api.NewApi(rt, cfg, ui)
 
 	addr := cfg.Application.GetHTTPAddr()
 	utils.Logger.Info("Starting API on http://localhost" + addr)
-	return http.ListenAndServe(addr, mux)
+	return cfg.Application.NewServer(addr, mux).ListenAndServe()
 }
 
 func StartRouter(cfg utils.Config, rt *router.Router) error {
 	defer rt.StopAll()
+	if cfg.UseHTTPS() {
+		rt.Deps.CertificateManager = cfg.CertificateManager.ACMEManager()
+		rt.Deps.Passthrough = true
+	}
 	mux := api.NewApi(rt, cfg, ui)
+	rt.Deps.ExposeHandler = mux
+	go rt.Init(cfg)
 	if ctrl, err := controller.NewConfigController(utils.ConfigPath, rt); err == nil {
 		rt.Controllers = append(rt.Controllers, ctrl)
 	}
 
 	if cfg.UseHTTPS() {
 		utils.Logger.Info("Certificates Managed by ACME")
-		manager := cfg.CertificateManager.ACMEManager()
+		manager := rt.Deps.CertificateManager
 		rt.Controllers = append(rt.Controllers, controller.NewACMEContoller(manager, cfg.CertificateManager))
 		go func() {
-			err := http.ListenAndServe(":80", manager.HTTPHandler(mux))
+			err := cfg.Application.NewServer(":80", manager.HTTPHandler(mux)).ListenAndServe()
 			log.Fatal(err)
 		}()
 
-		srv := &http.Server{
-			Addr:    cfg.CertificateManager.GetSSLAddr(),
-			Handler: mux,
-			TLSConfig: &tls.Config{
-				GetCertificate:           manager.GetCertificate,
-				PreferServerCipherSuites: true,
-				CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
-			},
+		srv := cfg.Application.NewServer(cfg.CertificateManager.GetSSLAddr(), mux)
+		srv.TLSConfig = &tls.Config{
+			GetCertificate:           manager.GetCertificate,
+			PreferServerCipherSuites: true,
+			CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+		}
+		listener, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			return err
 		}
-		return srv.ListenAndServeTLS("", "") // Key and cert provided automatically by autocert.
+		// TLS passthrough routes are split off by SNI before the HTTPS server sees the connection
+		return srv.ServeTLS(router.NewSNIListener(listener, rt), "", "") // Key and cert provided automatically by autocert.
 	} else {
 		addr := cfg.Application.GetHTTPAddr()
 		utils.Logger.Info("Starting API on http://localhost" + addr)
-		return http.ListenAndServe(addr, mux)
+		return cfg.Application.NewServer(addr, mux).ListenAndServe()
 	}
 }
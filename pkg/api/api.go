@@ -6,39 +6,49 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 	"warptail/pkg/auth"
 	botprotect "warptail/pkg/botProtect"
 	"warptail/pkg/router"
 	"warptail/pkg/utils"
+	"warptail/pkg/utils/logs"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"tailscale.com/client/tailscale/apitype"
 )
 
 type apiCtx string
 
 const SvcContext = apiCtx("service")
 
+// PeerContext holds the address of the connection peer before RealIP rewrites it
+const PeerContext = apiCtx("peer")
+
 type api struct {
 	*router.Router
 	authentication *auth.Authentication
 	botProtect     *botprotect.BotChallenge
+	compress       func(http.Handler) http.Handler
 }
 
 func NewApi(router *router.Router, config utils.Config, ui embed.FS) *chi.Mux {
 	db := utils.NewDB(config)
 	mux := chi.NewMux()
 	api := api{
-		Router: router,
+		Router:   router,
+		compress: middleware.Compress(5),
 	}
 	mux.Use(middleware.RequestID)
+	mux.Use(peerAddr)
 	mux.Use(middleware.RealIP)
 	mux.Use(middleware.Recoverer)
-	mux.Use(middleware.Compress(5))
 
+	// Proxied routes decide on compression themselves so streamed responses are not buffered
 	mux.Use(api.proxy)
+	mux.Use(api.compress)
 	mux.Use(utils.RequestLogger.Middleware)
 
 	mux.Use(cors.Handler(cors.Options{
@@ -63,6 +73,13 @@ func NewApi(router *router.Router, config utils.Config, ui embed.FS) *chi.Mux {
 			fmt.Fprintln(w, "router not ready")
 			return
 		}
+		for name, state := range router.TailnetStates() {
+			if !state.Running() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintln(w, "tailnet "+name+" "+state.State)
+				return
+			}
+		}
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintln(w, "ok")
 	})
@@ -81,15 +98,21 @@ func NewApi(router *router.Router, config utils.Config, ui embed.FS) *chi.Mux {
 			r.Get("/tailscale", api.handleTailscaleSettings)
 			r.Post("/tailscale", api.handleUpdateTailscaleSettings)
 			r.Get("/tailscale/status", api.handleUpdateTailscaleSatus)
+			r.Get("/tailnets", api.handleGetTailnets)
 			r.Get(("/logs"), api.handleGetLogs)
 		})
 		r.Get("/api/tailsale/nodes", api.handleGetTailscaleNodes)
+		r.Get("/api/tailsale/nodes/{node}/ports", api.handleProbeTailscaleNode)
 		r.Post("/api/services", api.handleCreateRoute)
 
 		r.Put("/api/services/{id}", api.handleUpdateRoute)
 		r.Delete("/api/services/{id}", api.handleDeleteRoute)
 		r.Post("/api/services/{id}/stop", api.handleStopRoute)
 		r.Post("/api/services/{id}/start", api.handleStartRoute)
+		r.Get("/api/services/{id}/connections", api.handleGetConnections)
+		r.Delete("/api/services/{id}/connections/{conn}", api.handleCloseConnection)
+		r.Post("/api/services/{id}/routes/{route}/diagnose", api.handleDiagnoseRoute)
+		r.Post("/api/services/{id}/routes/{route}/archive", api.handleUploadArchive)
 
 		r.Route("/api/user", func(r chi.Router) {
 			r.Get("/", api.authentication.HandleListUsers)
@@ -112,7 +135,10 @@ func (api *api) proxy(next http.Handler) http.Handler {
 		if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
 			host = host[:colonIndex]
 		}
-		route, err := api.GetHttpRoute(host)
+		svc, route, err := api.FindHttpRoute(host)
+		if domain, ok := router.ExposedDomain(r); ok {
+			svc, route, err = api.FindExposedRoute(domain)
+		}
 		if err != nil {
 			// No matching route found, continue to next handler (likely API or static files)
 			next.ServeHTTP(w, r)
@@ -122,11 +148,48 @@ func (api *api) proxy(next http.Handler) http.Handler {
 		// Mark this as a proxy request for logging
 		r = r.WithContext(context.WithValue(r.Context(), "isProxy", true))
 
-		if route.Config().Private {
+		hrw := &logs.HttpResponseWriter{ResponseWriter: w, StatusCode: http.StatusOK}
+		w = hrw
+		start := time.Now()
+		username := ""
+		defer func() {
+			api.logAccess(r, hrw, start, svc, route, username)
+		}()
+
+		config := route.Config()
+		var who *apitype.WhoIsResponse
+		if config.TailscaleIdentity != nil {
+			var whoErr error
+			if who, whoErr = api.WhoIs(r.Context(), svc.Tailnet, peerAddress(r)); whoErr != nil {
+				utils.Logger.V(1).Info("tailscale whois failed", "remote", peerAddress(r), "error", whoErr.Error())
+			}
+			if config.TailscaleIdentity.Headers {
+				router.ApplyIdentityHeaders(r.Header, who)
+			} else {
+				router.StripIdentityHeaders(r.Header)
+			}
+		}
+
+		// Private routes can be satisfied by the tailnet identity instead of a warptail session
+		if config.Private && router.IdentityAllowed(config.TailscaleIdentity, who) {
+			login, tags := router.IdentityLogin(who)
+			username = login
+			if username == "" {
+				username = strings.Join(tags, ",")
+			}
+		} else if config.Private {
 			authenticated := false
 			api.authentication.Authenticate(w, r, func(w http.ResponseWriter, r *http.Request) {
 				authenticated = true
 			})
+			if authenticated {
+				if user, err := api.authentication.GetUser(w, r); err == nil {
+					username = user.Username
+					if username == "" {
+						username = user.Email
+					}
+				}
+			}
 			if !authenticated {
 				// Log authentication failure to error log
 				if utils.RequestLogger != nil {
@@ -136,13 +199,44 @@ func (api *api) proxy(next http.Handler) http.Handler {
 			}
 		}
 
-		if route.Config().BotProtect && route.Config().Type == utils.HTTPS {
+		var handler http.Handler = http.HandlerFunc(route.Handle)
+		if streamer, ok := route.(interface{ IsStreaming(*http.Request) bool }); !ok || !streamer.IsStreaming(r) {
+			handler = api.compress(handler)
+		}
+
+		if route.Config().BotProtect && (route.Config().Type == utils.HTTPS || route.Config().Type == utils.FILES) {
 			// Bot protection middleware handles the challenge page and actual proxy
-			api.botProtect.Middleware(w, r, func(w http.ResponseWriter, r *http.Request) {
-				route.Handle(w, r)
-			})
+			api.botProtect.Middleware(w, r, handler.ServeHTTP)
 		} else {
-			route.Handle(w, r)
+			handler.ServeHTTP(w, r)
 		}
 	})
 }
+
+// peerAddr records the connection peer address, identity lookups must not trust forwarded headers
+func peerAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), PeerContext, r.RemoteAddr)))
+	})
+}
+
+func peerAddress(r *http.Request) string {
+	if addr, ok := r.Context().Value(PeerContext).(string); ok {
+		return addr
+	}
+	return r.RemoteAddr
+}
+
+func (api *api) logAccess(r *http.Request, hrw *logs.HttpResponseWriter, start time.Time, svc *router.Service, route router.HandlerRoute, username string) {
+	if utils.RequestLogger == nil {
+		return
+	}
+	config := route.Config()
+	entry := logs.NewAccessEntry(r, start, hrw.StatusCode, hrw.Size)
+	entry.Upstream = router.Upstream(config)
+	entry.Service = svc.Id
+	entry.Route = config.Domain
+	entry.User = username
+	entry.RequestID = middleware.GetReqID(r.Context())
+	utils.RequestLogger.LogAccess(r, entry, config.AccessLog)
+}
@@ -17,8 +17,14 @@ type ServiceMetrics struct {
 	TotalSent      *prometheus.GaugeVec
 	TotalReceived  *prometheus.GaugeVec
 
-	RouteStatus  *prometheus.GaugeVec
-	RouteLatency *prometheus.GaugeVec
+	RouteStatus      *prometheus.GaugeVec
+	RouteLatency     *prometheus.GaugeVec
+	RouteWebSockets  *prometheus.GaugeVec
+	RouteConnections *prometheus.GaugeVec
+
+	KeyExpiry        prometheus.Gauge
+	KeyExpiryWarning prometheus.Gauge
+	keyExpiryWarned  bool
 }
 
 // CreateMetrics initializes and registers Prometheus metrics for the service
@@ -55,9 +61,9 @@ func CreateMetrics() *ServiceMetrics {
 		RouteLatency: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "warptail_service_route_latency",
-				Help: "Latency of warptail route",
+				Help: "Latency of warptail route, labeled with the tailnet path (direct, derp or peer-relay) to its backend",
 			},
-			[]string{"service_name", "route_type", "route_entrypoint", "tailscale_address"},
+			[]string{"service_name", "route_type", "route_entrypoint", "tailscale_address", "tailnet_path", "derp_region"},
 		),
 		RouteStatus: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -66,6 +72,32 @@ func CreateMetrics() *ServiceMetrics {
 			},
 			[]string{"service_name", "route_type", "route_entrypoint", "tailscale_address"},
 		),
+		RouteWebSockets: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "warptail_route_websockets_active",
+				Help: "Number of open websocket connections on each warptail route",
+			},
+			[]string{"service_name", "route_type", "route_entrypoint", "tailscale_address"},
+		),
+		KeyExpiry: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "warptail_tailscale_key_expiry_seconds",
+				Help: "Seconds until the tailscale node key expires, -1 when key expiry is disabled",
+			},
+		),
+		KeyExpiryWarning: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "warptail_tailscale_key_expiry_warning",
+				Help: "Set to 1 when the tailscale node key expires within the warning window",
+			},
+		),
+		RouteConnections: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "warptail_route_connections_active",
+				Help: "Number of active TCP connections or UDP sessions on each warptail route",
+			},
+			[]string{"service_name", "route_type", "route_entrypoint", "tailscale_address"},
+		),
 	}
 }
 
@@ -74,6 +106,10 @@ func (metrics *ServiceMetrics) Register() {
 	prometheus.MustRegister(metrics.ServiceLatency)
 	prometheus.MustRegister(metrics.RouteLatency)
 	prometheus.MustRegister(metrics.RouteStatus)
+	prometheus.MustRegister(metrics.RouteWebSockets)
+	prometheus.MustRegister(metrics.RouteConnections)
+	prometheus.MustRegister(metrics.KeyExpiry)
+	prometheus.MustRegister(metrics.KeyExpiryWarning)
 	prometheus.MustRegister(metrics.TotalSent)
 	prometheus.MustRegister(metrics.TotalReceived)
 }
@@ -104,23 +140,40 @@ func (metrics *ServiceMetrics) Update(servics []router.Service) {
 			}
 			var label = []string{}
 			switch route.Type {
-			case utils.HTTP, utils.HTTPS:
+			case utils.HTTP, utils.HTTPS, utils.TLS_PASSTHROUGH:
+				entrypoint := route.Domain
+				if route.IsReverse() {
+					entrypoint = portLabel(route.RouteConfig)
+				}
+				label = []string{
+					service.Name,
+					string(route.Type),
+					entrypoint,
+					router.Upstream(route.RouteConfig),
+				}
+			case utils.FILES:
 				label = []string{
 					service.Name,
 					string(route.Type),
 					route.Domain,
-					fmt.Sprintf("%s:%d", route.Machine.Address, route.Machine.Port),
+					router.Upstream(route.RouteConfig),
 				}
 			case utils.TCP, utils.UDP:
 				label = []string{
 					service.Name,
 					string(route.Type),
-					strconv.Itoa(route.Port),
-					fmt.Sprintf("%s:%d", route.Machine.Address, route.Machine.Port),
+					portLabel(route.RouteConfig),
+					router.Upstream(route.RouteConfig),
 				}
 			}
 			metrics.RouteStatus.WithLabelValues(label...).Set(statusValue)
-			metrics.RouteLatency.WithLabelValues(label...).Set(float64(route.Latency))
+			metrics.updateRouteLatency(label, route)
+			if route.Type == utils.HTTP || route.Type == utils.HTTPS {
+				metrics.RouteWebSockets.WithLabelValues(label...).Set(float64(route.WebSockets))
+			}
+			if route.Type == utils.TCP || route.Type == utils.UDP || route.Type == utils.TLS_PASSTHROUGH {
+				metrics.RouteConnections.WithLabelValues(label...).Set(float64(route.Connections + route.Sessions))
+			}
 		}
 	}
 }
@@ -131,5 +184,51 @@ func (api *api) startMetrics() {
 	ticker := time.NewTicker(10 * time.Second)
 	for range ticker.C {
 		metrics.Update(api.All())
+		metrics.UpdateKeyExpiry(api.KeyExpiry())
+	}
+}
+
+// UpdateKeyExpiry updates the node key expiry metrics, logging once the warning window is reached
+func (metrics *ServiceMetrics) UpdateKeyExpiry(expiry *time.Time, warning bool) {
+	if expiry == nil || expiry.IsZero() {
+		metrics.KeyExpiry.Set(-1)
+		metrics.KeyExpiryWarning.Set(0)
+		return
+	}
+	metrics.KeyExpiry.Set(time.Until(*expiry).Seconds())
+	if warning && !metrics.keyExpiryWarned {
+		utils.Logger.Info("Tailscale node key expires soon", "expiry", expiry.String())
+	}
+	metrics.keyExpiryWarned = warning
+	if warning {
+		metrics.KeyExpiryWarning.Set(1)
+	} else {
+		metrics.KeyExpiryWarning.Set(0)
+	}
+}
+
+// updateRouteLatency sets the route latency under its current tailnet path,
+// dropping the series of the path it used before
+func (metrics *ServiceMetrics) updateRouteLatency(label []string, route router.RouteStatus) {
+	if len(label) == 0 {
+		return
+	}
+	path, region := "", ""
+	if route.TailnetPath != nil {
+		path, region = route.TailnetPath.Path, route.TailnetPath.DERPRegion
+	}
+	metrics.RouteLatency.DeletePartialMatch(prometheus.Labels{
+		"service_name":      label[0],
+		"route_type":        label[1],
+		"route_entrypoint":  label[2],
+		"tailscale_address": label[3],
+	})
+	metrics.RouteLatency.WithLabelValues(append(label, path, region)...).Set(float64(route.Latency))
+}
+
+func portLabel(route utils.RouteConfig) string {
+	if route.IsPortRange() {
+		return fmt.Sprintf("%d-%d", route.Port, route.PortEnd)
 	}
+	return strconv.Itoa(route.Port)
 }
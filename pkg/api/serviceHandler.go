@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"warptail/pkg/router"
 	"warptail/pkg/utils"
 
@@ -36,7 +37,7 @@ func (api *api) checkTailscaleAuth() error {
 	if api.Router == nil {
 		return fmt.Errorf("router not initialized")
 	}
-	peers, err := api.Router.GetPeers()
+	peers, err := api.Router.GetPeers(router.DefaultTailnet)
 	// Ensure we return a proper nil, not a typed nil
 	if err != nil || len(peers) == 0 {
 		return fmt.Errorf("no peers")
@@ -67,7 +68,7 @@ func (api *api) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *api) handleGetTailscaleNodes(w http.ResponseWriter, r *http.Request) {
-	nodes, err := api.Router.GetPeers()
+	nodes, err := api.Router.GetPeers(r.URL.Query().Get("tailnet"))
 	if err != nil {
 		// Check if error is related to Tailscale authentication
 		if isAuthError(err) {
@@ -80,6 +81,15 @@ func (api *api) handleGetTailscaleNodes(w http.ResponseWriter, r *http.Request)
 	utils.WriteData(w, nodes)
 }
 
+func (api *api) handleProbeTailscaleNode(w http.ResponseWriter, r *http.Request) {
+	ports, err := api.Router.ProbePeer(r.Context(), r.URL.Query().Get("tailnet"), chi.URLParam(r, "node"))
+	if err != nil {
+		utils.WriteErrorResponse(w, err)
+		return
+	}
+	utils.WriteData(w, ports)
+}
+
 // Helper function to determine if an error is authentication-related
 func isAuthError(err error) bool {
 	errMsg := err.Error()
@@ -112,6 +122,8 @@ func (api *api) handleUpdateRoute(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var svc utils.ServiceConfig
 	decoder.Decode(&svc)
+	// dialer passwords are never sent to the dashboard, an empty one keeps the stored value
+	api.KeepDialerPasswords(id, &svc)
 
 	service, err := api.Update(id, svc)
 	if err != nil {
@@ -152,3 +164,60 @@ func (api *api) handleStopRoute(w http.ResponseWriter, r *http.Request) {
 	api.Save()
 	utils.WriteData(w, service.Status(true))
 }
+
+func (api *api) handleGetConnections(w http.ResponseWriter, r *http.Request) {
+	service, err := api.Router.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteErrorResponse(w, err)
+		return
+	}
+	utils.WriteData(w, service.Connections())
+}
+
+func (api *api) handleCloseConnection(w http.ResponseWriter, r *http.Request) {
+	service, err := api.Router.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteErrorResponse(w, err)
+		return
+	}
+	if err := service.CloseConnection(chi.URLParam(r, "conn")); err != nil {
+		utils.WriteErrorResponse(w, err)
+		return
+	}
+	utils.WriteStatus(w, http.StatusOK)
+}
+
+func (api *api) handleDiagnoseRoute(w http.ResponseWriter, r *http.Request) {
+	service, err := api.Router.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteErrorResponse(w, err)
+		return
+	}
+	index, convErr := strconv.Atoi(chi.URLParam(r, "route"))
+	if convErr != nil {
+		utils.WriteErrorResponse(w, utils.BadReqError("invalid route index"))
+		return
+	}
+	diagnostic, err := service.Diagnose(r.Context(), index)
+	if err != nil {
+		utils.WriteErrorResponse(w, err)
+		return
+	}
+	utils.WriteData(w, diagnostic)
+}
+
+func (api *api) handleUploadArchive(w http.ResponseWriter, r *http.Request) {
+	index, convErr := strconv.Atoi(chi.URLParam(r, "route"))
+	if convErr != nil {
+		utils.WriteErrorResponse(w, utils.BadReqError("invalid route index"))
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, router.MaxArchiveSize)
+	service, err := api.UploadArchive(chi.URLParam(r, "id"), index, body)
+	if err != nil {
+		utils.WriteErrorResponse(w, err)
+		return
+	}
+	api.Save()
+	utils.WriteData(w, service.Status(true))
+}
@@ -6,14 +6,21 @@ import (
 	"warptail/pkg/utils"
 )
 
+// handleTailscaleSettings returns the tailscale config without the OAuth client secret
 func (api *api) handleTailscaleSettings(w http.ResponseWriter, r *http.Request) {
-	utils.WriteData(w, api.Router.GetTailScaleConfig())
+	config := api.Router.GetTailScaleConfig()
+	config.ClientSecret = ""
+	utils.WriteData(w, config)
 }
 
 func (api *api) handleUpdateTailscaleSettings(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var tsc utils.TailscaleConfig
 	decoder.Decode(&tsc)
+	// the secret is never sent to the dashboard, an empty one keeps the stored value
+	if len(tsc.ClientSecret) == 0 {
+		tsc.ClientSecret = api.Router.GetTailScaleConfig().ClientSecret
+	}
 	api.SaveTailScale(tsc)
 	utils.WriteStatus(w, http.StatusOK)
 }
@@ -22,6 +29,10 @@ func (api *api) handleUpdateTailscaleSatus(w http.ResponseWriter, r *http.Reques
 	utils.WriteData(w, api.GetTailScaleStatus())
 }
 
+func (api *api) handleGetTailnets(w http.ResponseWriter, r *http.Request) {
+	utils.WriteData(w, api.GetTailnetStatus())
+}
+
 func (api *api) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	var logs []string
 	var err error
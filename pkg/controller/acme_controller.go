@@ -24,7 +24,11 @@ func (ctrl *ACMEController) Update(router *router.Router) {
 	for _, svc := range router.Services {
 		for _, route := range svc.Routes {
 			cfg := route.Config()
-			if cfg.Type == utils.HTTPS {
+			// Routes exposed only on the tailnet use the node tailnet certificate
+			if (cfg.Type == utils.HTTPS && cfg.IsPublic()) || cfg.Type == utils.FILES {
+				domains = append(domains, cfg.Domain)
+			}
+			if cfg.Type == utils.TCP && cfg.TLS != nil && cfg.TLS.UseACME() {
 				domains = append(domains, cfg.Domain)
 			}
 		}
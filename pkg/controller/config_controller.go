@@ -48,6 +48,7 @@ func (ctrl *ConfigCtrl) Update(router *router.Router) {
 		config := utils.ServiceConfig{
 			Name:    svc.Name,
 			Enabled: svc.Enabled,
+			Tailnet: svc.Tailnet,
 			Routes:  []utils.RouteConfig{},
 		}
 		for _, route := range svc.Routes {
@@ -57,6 +58,7 @@ func (ctrl *ConfigCtrl) Update(router *router.Router) {
 	}
 	config, _ := utils.LoadConfig(ctrl.path)
 	config.Tailscale = router.GetTailScaleConfig()
+	config.Tailnets = router.GetTailnetConfigs()
 	ctrl.lastHash = utils.ConfigHash(ctrl.path)
 	config.Services = svcs
 	ctrl.Save(config)
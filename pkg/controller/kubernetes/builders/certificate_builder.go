@@ -37,7 +37,7 @@ func NewCertifcationBuilder(config utils.KubernetesConfig, k8cfg *rest.Config) *
 func (ctrl *CertifcationBuilder) build(routes []utils.RouteConfig) certmanagerv1.Certificate {
 	DNSNames := []string{}
 	for _, route := range routes {
-		if route.Type == utils.HTTPS {
+		if route.Type == utils.HTTPS && route.IsPublic() {
 			DNSNames = append(DNSNames, route.Domain)
 		}
 	}
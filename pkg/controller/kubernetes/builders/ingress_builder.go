@@ -48,7 +48,7 @@ func (ctrl *IngressBuilder) build(routes []utils.RouteConfig) networkingv1.Ingre
 	}
 
 	for _, route := range routes {
-		if route.Type != utils.HTTP && route.Type != utils.HTTPS {
+		if (route.Type != utils.HTTP && route.Type != utils.HTTPS) || !route.IsPublic() {
 			continue
 		}
 		rule := networkingv1.IngressRule{
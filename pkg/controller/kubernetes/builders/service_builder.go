@@ -58,25 +58,28 @@ func (ctrl *LoadbalancerBuilder) build(routes []utils.RouteConfig, existingPorts
 	}
 
 	for _, route := range routes {
-		if route.Type != utils.TCP && route.Type != utils.UDP {
+		// Reverse routes listen on the tailnet node and need no service port
+		if (route.Type != utils.TCP && route.Type != utils.UDP) || route.IsReverse() {
 			continue
 		}
 		protocol := corev1.ProtocolTCP
 		if route.Type == utils.UDP {
 			protocol = corev1.ProtocolUDP
 		}
-		port := corev1.ServicePort{
-			Name:       fmt.Sprintf("%s-%d", string(route.Type), route.Port),
-			Port:       int32(route.Port),
-			TargetPort: intstr.FromInt(route.Port),
-			Protocol:   protocol,
+		for _, routePort := range route.Ports() {
+			port := corev1.ServicePort{
+				Name:       fmt.Sprintf("%s-%d", string(route.Type), routePort),
+				Port:       int32(routePort),
+				TargetPort: intstr.FromInt(routePort),
+				Protocol:   protocol,
+			}
+			// Preserve existing nodePort if available to avoid conflicts
+			key := fmt.Sprintf("%s-%d", protocol, routePort)
+			if nodePort, ok := existingPortMap[key]; ok {
+				port.NodePort = nodePort
+			}
+			service.Spec.Ports = append(service.Spec.Ports, port)
 		}
-		// Preserve existing nodePort if available to avoid conflicts
-		key := fmt.Sprintf("%s-%d", protocol, route.Port)
-		if nodePort, ok := existingPortMap[key]; ok {
-			port.NodePort = nodePort
-		}
-		service.Spec.Ports = append(service.Spec.Ports, port)
 	}
 	return service
 }
@@ -24,6 +24,7 @@ var (
 
 type ServiceConfig struct {
 	Enabled bool                `yaml:"enabled" json:"enabled,omitempty"`
+	Tailnet string              `yaml:"tailnet,omitempty" json:"tailnet,omitempty"`
 	Routes  []utils.RouteConfig `yaml:"routes" json:"routes"`
 }
 
@@ -49,6 +50,7 @@ func (w *WarpTailService) ToServiceConfig() utils.ServiceConfig {
 	return utils.ServiceConfig{
 		Name:    w.Name,
 		Enabled: w.Spec.Enabled,
+		Tailnet: w.Spec.Tailnet,
 		Routes:  w.Spec.Routes,
 	}
 }
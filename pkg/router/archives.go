@@ -0,0 +1,112 @@
+package router
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"warptail/pkg/utils"
+)
+
+// MaxArchiveSize is the largest zip archive accepted for a file route
+const MaxArchiveSize = 256 << 20
+
+// UploadArchive stores the zip archive read from body under utils.ArchivePath and
+// points the file route at index to it, replacing the previous uploaded archive
+func (r *Router) UploadArchive(id string, index int, body io.Reader) (*Service, *utils.RouterError) {
+	svc, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	valid := index >= 0 && index < len(svc.Routes) && svc.Routes[index].Config().Type == utils.FILES
+	r.mu.RUnlock()
+	if !valid {
+		return nil, utils.NotFoundError("file route not found")
+	}
+
+	name, err := saveArchive(fmt.Sprintf("%s-%d-%d.zip", svc.Id, index, time.Now().UnixNano()), body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if index >= len(svc.Routes) || svc.Routes[index].Config().Type != utils.FILES {
+		r.mu.Unlock()
+		os.Remove(name)
+		return nil, utils.NotFoundError("file route not found")
+	}
+	route := svc.Routes[index]
+	config := route.Config()
+	settings := utils.FileSettings{}
+	if config.FileSettings != nil {
+		settings = *config.FileSettings
+	}
+	previous := settings.Archive
+	settings.Archive = name
+	settings.Root = ""
+	config.FileSettings = &settings
+	updateErr := route.Update(config)
+	// a route that had nothing to serve starts once it has an archive
+	if updateErr == nil && svc.Enabled && route.Status() != RUNNING {
+		updateErr = route.Start()
+	}
+	r.mu.Unlock()
+
+	if isUploadedArchive(previous) {
+		os.Remove(previous)
+	}
+	if updateErr != nil {
+		return svc, utils.BadReqError("unable to open archive: " + updateErr.Error())
+	}
+	return svc, nil
+}
+
+// saveArchive writes the upload to a temporary file and moves it in place once it
+// is known to be a readable zip archive
+func saveArchive(name string, body io.Reader) (string, *utils.RouterError) {
+	if err := os.MkdirAll(utils.ArchivePath, 0o755); err != nil {
+		return "", utils.CustomError(http.StatusInternalServerError, "unable to create archive directory")
+	}
+	tmp, err := os.CreateTemp(utils.ArchivePath, "upload-*.zip")
+	if err != nil {
+		return "", utils.CustomError(http.StatusInternalServerError, "unable to store archive")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, body)
+	tmp.Close()
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return "", utils.CustomError(http.StatusRequestEntityTooLarge, fmt.Sprintf("archive exceeds %d bytes", maxBytesErr.Limit))
+		}
+		return "", utils.BadReqError("unable to read archive")
+	}
+	archive, err := zip.OpenReader(tmp.Name())
+	if err != nil {
+		return "", utils.BadReqError("archive is not a valid zip file")
+	}
+	archive.Close()
+
+	name = filepath.Join(utils.ArchivePath, name)
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return "", utils.CustomError(http.StatusInternalServerError, "unable to store archive")
+	}
+	return name, nil
+}
+
+// isUploadedArchive reports whether the archive was stored by UploadArchive, archives
+// configured by hand are never removed
+func isUploadedArchive(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(utils.ArchivePath, name)
+	return err == nil && !strings.HasPrefix(rel, "..") && filepath.Dir(rel) == "."
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+	"warptail/pkg/utils"
+)
+
+var connectionIDs atomic.Uint64
+
+// Connection is a live TCP connection or UDP session on a route
+type Connection struct {
+	Id       string    `json:"id"`
+	Port     int       `json:"port"`
+	Client   string    `json:"client"`
+	Backend  string    `json:"backend"`
+	Started  time.Time `json:"started"`
+	Sent     uint64    `json:"sent"`
+	Received uint64    `json:"received"`
+	Idle     int64     `json:"idle"`
+}
+
+// RouteConnections lists the live connections of a single route
+type RouteConnections struct {
+	Type        utils.RouteType `json:"type"`
+	Port        int             `json:"port"`
+	PortEnd     int             `json:"port_end,omitempty"`
+	Connections []Connection    `json:"connections"`
+}
+
+// ConnectionRoute is implemented by routes that can list and close their connections
+type ConnectionRoute interface {
+	ActiveConnections() int64
+	Connections() []Connection
+	CloseConnection(id string) bool
+}
+
+// connStats tracks the traffic of a single connection or session
+type connStats struct {
+	id         string
+	port       int
+	client     string
+	backend    string
+	started    time.Time
+	sent       atomic.Uint64
+	received   atomic.Uint64
+	lastActive atomic.Int64
+}
+
+func newConnStats(port int, client, backend string) *connStats {
+	stats := &connStats{
+		id:      strconv.FormatUint(connectionIDs.Add(1), 10),
+		port:    port,
+		client:  client,
+		backend: backend,
+		started: time.Now(),
+	}
+	stats.lastActive.Store(stats.started.UnixNano())
+	return stats
+}
+
+func (stats *connStats) logSent(n uint64) {
+	stats.sent.Add(n)
+	stats.lastActive.Store(time.Now().UnixNano())
+}
+
+func (stats *connStats) logReceived(n uint64) {
+	stats.received.Add(n)
+	stats.lastActive.Store(time.Now().UnixNano())
+}
+
+func (stats *connStats) connection() Connection {
+	return Connection{
+		Id:       stats.id,
+		Port:     stats.port,
+		Client:   stats.client,
+		Backend:  stats.backend,
+		Started:  stats.started,
+		Sent:     stats.sent.Load(),
+		Received: stats.received.Load(),
+		Idle:     time.Since(time.Unix(0, stats.lastActive.Load())).Nanoseconds(),
+	}
+}
+
+// Connections lists the live connections of every TCP and UDP route in the service
+func (svc *Service) Connections() []RouteConnections {
+	connections := []RouteConnections{}
+	for _, route := range svc.Routes {
+		if conns, ok := route.(ConnectionRoute); ok {
+			config := route.Config()
+			connections = append(connections, RouteConnections{
+				Type:        config.Type,
+				Port:        config.Port,
+				PortEnd:     config.PortEnd,
+				Connections: conns.Connections(),
+			})
+		}
+	}
+	return connections
+}
+
+// CloseConnection closes a single connection on any of the service routes
+func (svc *Service) CloseConnection(id string) *utils.RouterError {
+	for _, route := range svc.Routes {
+		if conns, ok := route.(ConnectionRoute); ok && conns.CloseConnection(id) {
+			return nil
+		}
+	}
+	return utils.NotFoundError("connection not found")
+}
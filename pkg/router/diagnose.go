@@ -0,0 +1,251 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"warptail/pkg/utils"
+
+	"tailscale.com/client/local"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsnet"
+)
+
+const diagnoseTimeout = 5 * time.Second
+
+// DiagnosticResult is the conclusion of a route diagnostic
+type DiagnosticResult string
+
+const (
+	DiagnosticOK              = DiagnosticResult("ok")
+	DiagnosticPeerUnreachable = DiagnosticResult("peer_unreachable")
+	DiagnosticBlocked         = DiagnosticResult("blocked")
+	DiagnosticBackendDown     = DiagnosticResult("backend_down")
+	DiagnosticFailed          = DiagnosticResult("failed")
+)
+
+// Path values describe how packets reach the peer
+const (
+	PathDirect    = "direct"
+	PathDERP      = "derp"
+	PathPeerRelay = "peer-relay"
+)
+
+// DiagnosticCheck is the outcome of one probe, latency is in nanoseconds like
+// the route latency
+type DiagnosticCheck struct {
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Latency int64  `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	refused  bool
+	timedOut bool
+}
+
+// RouteDiagnostic reports whether the backend of a route is reachable over the
+// tailnet and, when it is not, whether the peer, the tailnet ACLs or the backend
+// itself is at fault
+type RouteDiagnostic struct {
+	Target     string           `json:"target"`
+	Peer       string           `json:"peer,omitempty"`
+	PeerIP     string           `json:"peer_ip,omitempty"`
+	Path       string           `json:"path,omitempty"`
+	Endpoint   string           `json:"endpoint,omitempty"`
+	DERPRegion string           `json:"derp_region,omitempty"`
+	Disco      DiagnosticCheck  `json:"disco"`
+	TSMP       DiagnosticCheck  `json:"tsmp"`
+	Dial       DiagnosticCheck  `json:"dial"`
+	Result     DiagnosticResult `json:"result"`
+	Message    string           `json:"message"`
+	Checked    time.Time        `json:"checked"`
+}
+
+// DiagnosableRoute is implemented by routes whose backend can be probed
+type DiagnosableRoute interface {
+	Route
+	Diagnose(ctx context.Context) RouteDiagnostic
+}
+
+// diagnostics keeps the last diagnostic of each route for the route status
+var diagnostics sync.Map
+
+func lastDiagnostic(route Route) *RouteDiagnostic {
+	if value, ok := diagnostics.Load(route); ok {
+		diagnostic := value.(RouteDiagnostic)
+		return &diagnostic
+	}
+	return nil
+}
+
+// Diagnose probes the backend of the route at index, the result is kept and
+// returned in the route status until the next run
+func (svc *Service) Diagnose(ctx context.Context, index int) (RouteDiagnostic, *utils.RouterError) {
+	if index < 0 || index >= len(svc.Routes) {
+		return RouteDiagnostic{}, utils.NotFoundError("route not found")
+	}
+	route := svc.Routes[index]
+	diagnosable, ok := route.(DiagnosableRoute)
+	if !ok || !route.Config().DialsTailnet() {
+		return RouteDiagnostic{}, utils.BadReqError("route does not dial its backend through the tailnet")
+	}
+	diagnostic := diagnosable.Diagnose(ctx)
+	diagnostics.Store(route, diagnostic)
+	return diagnostic, nil
+}
+
+// diagnose pings the peer handling the backend address over disco, which only
+// needs a path between the nodes, and TSMP, which needs WireGuard to pass
+// packets, then dials the backend. ACL rejections are not answered with a
+// reset, so a dial timing out while the peer answers pings points at the ACLs.
+func diagnose(ctx context.Context, config utils.RouteConfig, ts *tsnet.Server, deps RouteDeps) RouteDiagnostic {
+	// a diagnostic is requested by hand, so it waits for current node addresses
+	if ts != nil {
+		if err := resolverFor(ts).refresh(); err != nil {
+			utils.Logger.V(1).Info("unable to load tailscale nodes", "error", err.Error())
+		}
+	}
+	host := machineHost(config, ts)
+	diagnostic := RouteDiagnostic{
+		Target:  net.JoinHostPort(host, strconv.Itoa(int(config.Machine.Port))),
+		Checked: time.Now(),
+	}
+	if ts == nil {
+		return diagnostic.conclusion(DiagnosticFailed, "tailscale is not running")
+	}
+	client, err := ts.LocalClient()
+	if err != nil {
+		return diagnostic.conclusion(DiagnosticFailed, "tailscale is not running")
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		if resolved, ok := resolverFor(ts).resolve(host); ok {
+			addr, err = netip.ParseAddr(resolved)
+		}
+	}
+	if err != nil {
+		diagnostic.Disco = DiagnosticCheck{Skipped: true, Error: "backend is not a tailnet node or IP address"}
+		diagnostic.TSMP = diagnostic.Disco
+	} else {
+		var result *ipnstate.PingResult
+		diagnostic.Disco, result = ping(ctx, client, addr, tailcfg.PingDisco)
+		diagnostic.setPath(result)
+		diagnostic.TSMP, result = ping(ctx, client, addr, tailcfg.PingTSMP)
+		diagnostic.setPath(result)
+	}
+
+	if config.Type == utils.UDP {
+		diagnostic.Dial = DiagnosticCheck{Skipped: true, Error: "udp backends cannot be dial tested"}
+	} else {
+		diagnostic.Dial = dialCheck(ctx, routeDialer(config, ts, deps), diagnostic.Target)
+	}
+	return diagnostic.conclude()
+}
+
+func ping(ctx context.Context, client *local.Client, addr netip.Addr, pingType tailcfg.PingType) (DiagnosticCheck, *ipnstate.PingResult) {
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+	result, err := client.Ping(ctx, addr, pingType)
+	if err != nil {
+		return DiagnosticCheck{Error: err.Error()}, nil
+	}
+	if len(result.Err) > 0 {
+		return DiagnosticCheck{Error: result.Err}, result
+	}
+	latency := time.Duration(result.LatencySeconds * float64(time.Second))
+	return DiagnosticCheck{OK: true, Latency: latency.Nanoseconds()}, result
+}
+
+func dialCheck(ctx context.Context, dialer Dialer, target string) DiagnosticCheck {
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		var netErr net.Error
+		return DiagnosticCheck{
+			Error: err.Error(),
+			// netstack reports refused connections by message only
+			refused:  errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || strings.Contains(err.Error(), "refused"),
+			timedOut: errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()),
+		}
+	}
+	conn.Close()
+	return DiagnosticCheck{OK: true, Latency: time.Since(start).Nanoseconds()}
+}
+
+// setPath records the peer and the path a ping took, the first successful ping wins
+func (diagnostic *RouteDiagnostic) setPath(result *ipnstate.PingResult) {
+	if result == nil || len(result.Err) > 0 || len(diagnostic.Path) > 0 {
+		return
+	}
+	diagnostic.Peer = result.NodeName
+	diagnostic.PeerIP = result.NodeIP
+	switch {
+	case len(result.Endpoint) > 0:
+		diagnostic.Path = PathDirect
+		diagnostic.Endpoint = result.Endpoint
+	case len(result.PeerRelay) > 0:
+		diagnostic.Path = PathPeerRelay
+		diagnostic.Endpoint = result.PeerRelay
+	case len(result.DERPRegionCode) > 0:
+		diagnostic.Path = PathDERP
+		diagnostic.DERPRegion = result.DERPRegionCode
+	}
+}
+
+func (diagnostic RouteDiagnostic) conclusion(result DiagnosticResult, message string) RouteDiagnostic {
+	diagnostic.Result = result
+	diagnostic.Message = message
+	return diagnostic
+}
+
+func (diagnostic RouteDiagnostic) conclude() RouteDiagnostic {
+	reachable := diagnostic.Disco.OK || diagnostic.TSMP.OK
+	pinged := !diagnostic.Disco.Skipped
+	switch {
+	case !pinged && diagnostic.Dial.Skipped:
+		return diagnostic.conclusion(DiagnosticFailed, "nothing could be tested, the backend is not a tailnet node or IP address and udp backends cannot be dialed")
+	case diagnostic.Dial.OK:
+		return diagnostic.conclusion(DiagnosticOK, "backend accepted the connection")
+	case pinged && !reachable:
+		return diagnostic.conclusion(DiagnosticPeerUnreachable, "the peer handling the backend does not answer pings, it is offline or no path exists")
+	case diagnostic.Dial.Skipped:
+		if diagnostic.TSMP.OK {
+			return diagnostic.conclusion(DiagnosticOK, "peer is reachable, the udp backend itself was not tested")
+		}
+		return diagnostic.conclusion(DiagnosticBlocked, "the peer answers disco but not TSMP pings, WireGuard traffic is not getting through")
+	case diagnostic.Dial.refused:
+		return diagnostic.conclusion(DiagnosticBackendDown, "the peer is reachable but nothing is listening on the backend port")
+	case diagnostic.Dial.timedOut && reachable:
+		return diagnostic.conclusion(DiagnosticBlocked, "the peer is reachable but the connection was dropped, the tailnet ACLs or a firewall block warptail")
+	}
+	return diagnostic.conclusion(DiagnosticFailed, "unable to connect to the backend")
+}
+
+func (route *HTTPRoute) Diagnose(ctx context.Context) RouteDiagnostic {
+	return diagnose(ctx, route.Config(), route.ts, route.deps)
+}
+
+func (route *TCPRoute) Diagnose(ctx context.Context) RouteDiagnostic {
+	return diagnose(ctx, route.Config(), route.client, route.deps)
+}
+
+func (route *UDPRoute) Diagnose(ctx context.Context) RouteDiagnostic {
+	return diagnose(ctx, route.Config(), route.client, route.deps)
+}
+
+// Diagnose probes the first port of the range
+func (route *PortRangeRoute) Diagnose(ctx context.Context) RouteDiagnostic {
+	config := route.Config()
+	config.PortEnd = 0
+	return diagnose(ctx, config, route.server, route.deps)
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+	"warptail/pkg/utils"
+
+	"golang.org/x/net/proxy"
+	"tailscale.com/tsnet"
+)
+
+// Dialer opens connections from a route to its backend
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+type dialerFunc dialFunc
+
+func (fn dialerFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return fn(ctx, network, addr)
+}
+
+func (fn dialerFunc) Dial(network, addr string) (net.Conn, error) {
+	return fn(context.Background(), network, addr)
+}
+
+// routeDialer returns the dialer selected by the route config
+func routeDialer(config utils.RouteConfig, ts *tsnet.Server, deps RouteDeps) Dialer {
+	if deps.dialer != nil {
+		return deps.dialer
+	}
+	switch config.DialerType() {
+	case utils.TailscaleDialer:
+		return dialerFunc(ts.Dial)
+	case utils.SOCKS5Dialer:
+		return socks5Dialer(config.Dialer, proxyForward(config.Dialer, ts))
+	case utils.HTTPConnectDialer:
+		return &connectDialer{settings: config.Dialer, forward: proxyForward(config.Dialer, ts)}
+	}
+	return &net.Dialer{}
+}
+
+// proxyForward returns how the upstream proxy itself is reached
+func proxyForward(settings *utils.DialerSettings, ts *tsnet.Server) Dialer {
+	if settings.ViaTailnet && ts != nil {
+		return dialerFunc(ts.Dial)
+	}
+	return &net.Dialer{}
+}
+
+func socks5Dialer(settings *utils.DialerSettings, forward Dialer) Dialer {
+	var auth *proxy.Auth
+	if len(settings.Username) > 0 {
+		auth = &proxy.Auth{User: settings.Username, Password: settings.Password}
+	}
+	dialer, err := proxy.SOCKS5("tcp", settings.Proxy, auth, forward.(proxy.Dialer))
+	if err != nil {
+		return dialerFunc(func(context.Context, string, string) (net.Conn, error) { return nil, err })
+	}
+	return dialer.(proxy.ContextDialer)
+}
+
+// connectDialer tunnels connections through an HTTP CONNECT proxy
+type connectDialer struct {
+	settings *utils.DialerSettings
+	forward  Dialer
+}
+
+func (dialer *connectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("http connect proxy does not support network %s", network)
+	}
+	conn, err := dialer.forward.DialContext(ctx, "tcp", dialer.settings.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if len(dialer.settings.Username) > 0 {
+		credentials := dialer.settings.Username + ":" + dialer.settings.Password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("http connect proxy refused %s: %s", addr, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn keeps bytes the proxy sent along with its CONNECT response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
@@ -0,0 +1,27 @@
+package router
+
+import (
+	"context"
+	"net"
+	"warptail/pkg/utils"
+
+	"tailscale.com/tsnet"
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// listenTCP opens the listener of a route, forward routes bind on the host
+// while reverse routes bind on the tailnet node.
+func listenTCP(config utils.RouteConfig, ts *tsnet.Server) (net.Listener, error) {
+	if config.IsReverse() {
+		return ts.Listen(config.ListenNetwork(), config.ListenAddr())
+	}
+	return net.Listen(config.ListenNetwork(), config.ListenAddr())
+}
+
+// backendDialer returns how a route reaches its backend, forward routes dial into
+// the tailnet and reverse routes dial the LAN or internet from the host unless
+// the route selects another dialer.
+func backendDialer(config utils.RouteConfig, ts *tsnet.Server, deps RouteDeps) dialFunc {
+	return routeDialer(config, ts, deps).DialContext
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"warptail/pkg/utils"
+
+	"tailscale.com/tsnet"
+)
+
+type exposeCtx struct{}
+
+// ExposedDomain returns the domain of the route a request was received for
+// when it arrived through Tailscale Funnel or the tailnet listener
+func ExposedDomain(r *http.Request) (string, bool) {
+	domain, ok := r.Context().Value(exposeCtx{}).(string)
+	return domain, ok
+}
+
+// listenExposed listens on the tailnet node for an exposed https route, the
+// listener terminates TLS with the node tailnet certificate
+func listenExposed(config utils.RouteConfig, ts *tsnet.Server) (net.Listener, error) {
+	addr := fmt.Sprintf(":%d", config.ExposePort())
+	switch config.Expose {
+	case utils.ExposeFunnel:
+		return ts.ListenFunnel("tcp", addr, tsnet.FunnelOnly())
+	case utils.ExposePublicFunnel:
+		return ts.ListenFunnel("tcp", addr)
+	default:
+		return ts.ListenTLS("tcp", addr)
+	}
+}
+
+// serveExposed tags the request with the route domain and hands it to the shared handler
+func (route *HTTPRoute) serveExposed(w http.ResponseWriter, r *http.Request) {
+	if route.deps.ExposeHandler == nil {
+		route.serveTailnet(w, r)
+		return
+	}
+	ctx := context.WithValue(r.Context(), exposeCtx{}, route.Config().Domain)
+	route.deps.ExposeHandler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// TailnetURL returns the address an exposed route is reachable on, empty when
+// the route is not exposed or the tailnet has no HTTPS certificates enabled
+func (route *HTTPRoute) TailnetURL() string {
+	config := route.Config()
+	if !config.IsTailnetExposed() || route.ts == nil {
+		return ""
+	}
+	domains := route.ts.CertDomains()
+	if len(domains) == 0 {
+		return ""
+	}
+	host := strings.TrimSuffix(domains[0], ".")
+	if port := config.ExposePort(); port != 443 {
+		host = net.JoinHostPort(host, fmt.Sprint(port))
+	}
+	return "https://" + host
+}
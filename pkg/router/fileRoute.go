@@ -0,0 +1,306 @@
+package router
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"warptail/pkg/utils"
+)
+
+var defaultIndexFiles = []string{"index.html", "index.htm"}
+
+// maxBufferedEntry caps the compressed archive entries read into memory so range
+// and conditional requests work, larger ones are streamed whole
+const maxBufferedEntry = 4 << 20
+
+// FileRoute serves static files from a local directory or zip archive on the
+// warptail host, so small sites do not need a separate web server.
+type FileRoute struct {
+	config utils.RouteConfig
+	data   *utils.TimeSeries
+
+	mu      sync.RWMutex
+	status  RouterStatus
+	fsys    fs.FS
+	archive *zip.ReadCloser
+	entries map[string]*zip.File
+	latency time.Duration
+}
+
+func NewFileRoute(config utils.RouteConfig) *FileRoute {
+	return &FileRoute{
+		config: config,
+		data:   utils.NewTimeSeries(time.Second, 1000),
+		status: STOPPED,
+	}
+}
+
+func (route *FileRoute) Status() RouterStatus {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	return route.status
+}
+
+func (route *FileRoute) Config() utils.RouteConfig {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	return route.config
+}
+
+func (route *FileRoute) Stats() utils.TimeSeriesData {
+	return route.data.Data
+}
+
+func (route *FileRoute) Ping() time.Duration {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	return route.latency
+}
+
+func (route *FileRoute) Update(config utils.RouteConfig) error {
+	running := route.Status() == RUNNING
+	if running {
+		route.Stop()
+	}
+	route.mu.Lock()
+	route.config = config
+	route.mu.Unlock()
+	if running {
+		return route.Start()
+	}
+	return nil
+}
+
+func (route *FileRoute) Start() error {
+	route.mu.Lock()
+	defer route.mu.Unlock()
+	if route.status == RUNNING {
+		return nil
+	}
+	route.status = STARTING
+
+	start := time.Now()
+	if err := route.open(); err != nil {
+		route.status = STOPPED
+		route.latency = -1
+		utils.Logger.Error(err, "unable to open file route", "domain", route.config.Domain)
+		return err
+	}
+	route.latency = time.Since(start)
+	route.status = RUNNING
+	return nil
+}
+
+func (route *FileRoute) Stop() error {
+	route.mu.Lock()
+	defer route.mu.Unlock()
+	if route.status != RUNNING {
+		return fmt.Errorf("route not running")
+	}
+	route.status = STOPPING
+	if route.archive != nil {
+		route.archive.Close()
+		route.archive = nil
+		route.entries = nil
+	}
+	route.fsys = nil
+	route.status = STOPPED
+	return nil
+}
+
+// open prepares the filesystem backing the route, must be called with the lock held
+func (route *FileRoute) open() error {
+	settings := route.config.FileSettings
+	if settings == nil {
+		return fmt.Errorf("missing file settings")
+	}
+	if len(settings.Archive) > 0 {
+		archive, err := zip.OpenReader(settings.Archive)
+		if err != nil {
+			return err
+		}
+		route.archive = archive
+		route.fsys = archive
+		route.entries = make(map[string]*zip.File, len(archive.File))
+		for _, file := range archive.File {
+			route.entries[file.Name] = file
+		}
+		return nil
+	}
+	info, err := os.Stat(settings.Root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", settings.Root)
+	}
+	route.fsys = os.DirFS(settings.Root)
+	return nil
+}
+
+func (route *FileRoute) indexFiles() []string {
+	if route.config.FileSettings != nil && len(route.config.FileSettings.IndexFiles) > 0 {
+		return route.config.FileSettings.IndexFiles
+	}
+	return defaultIndexFiles
+}
+
+func (route *FileRoute) Handle(w http.ResponseWriter, r *http.Request) {
+	route.mu.RLock()
+	fsys := route.fsys
+	status := route.status
+	settings := utils.FileSettings{}
+	if route.config.FileSettings != nil {
+		settings = *route.config.FileSettings
+	}
+	indexFiles := route.indexFiles()
+	route.mu.RUnlock()
+
+	if status != RUNNING || fsys == nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	rr := NewResponseRecorder(w)
+	defer func() {
+		route.data.LogRecived(uint64(rr.responseSize))
+	}()
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && settings.SPA {
+			route.serveIndex(rr, r, fsys, ".", indexFiles)
+			return
+		}
+		http.NotFound(rr, r)
+		return
+	}
+
+	if !info.IsDir() {
+		route.serveFile(rr, r, fsys, name, settings.CacheMaxAge)
+		return
+	}
+
+	if route.serveIndex(rr, r, fsys, name, indexFiles) {
+		return
+	}
+	if settings.DirectoryListing {
+		http.FileServerFS(fsys).ServeHTTP(rr, r)
+		return
+	}
+	if settings.SPA {
+		if route.serveIndex(rr, r, fsys, ".", indexFiles) {
+			return
+		}
+	}
+	http.Error(rr, "Forbidden", http.StatusForbidden)
+}
+
+// serveIndex serves the first index file found in dir, the index is never cached
+// so that SPA deployments pick up new asset hashes immediately.
+func (route *FileRoute) serveIndex(w http.ResponseWriter, r *http.Request, fsys fs.FS, dir string, indexFiles []string) bool {
+	for _, index := range indexFiles {
+		name := path.Join(dir, index)
+		if info, err := fs.Stat(fsys, name); err == nil && !info.IsDir() {
+			w.Header().Set("Cache-Control", "no-cache")
+			route.serveFile(w, r, fsys, name, 0)
+			return true
+		}
+	}
+	return false
+}
+
+func (route *FileRoute) serveFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, maxAge int) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		content, err = route.archiveContent(f, name, info.Size())
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if content == nil {
+		streamContent(w, r, f, info)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// archiveContent returns a seekable reader for an archive entry, stored entries
+// are read in place and small compressed ones buffered. It is nil for compressed
+// entries over maxBufferedEntry.
+func (route *FileRoute) archiveContent(f fs.File, name string, size int64) (io.ReadSeeker, error) {
+	route.mu.RLock()
+	entry := route.entries[name]
+	route.mu.RUnlock()
+	if entry != nil && entry.Method == zip.Store {
+		if raw, err := entry.OpenRaw(); err == nil {
+			if content, ok := raw.(io.ReadSeeker); ok {
+				return content, nil
+			}
+		}
+	}
+	if size > maxBufferedEntry {
+		return nil, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxBufferedEntry))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// streamContent copies a non-seekable file to the response, range requests are
+// answered with the full content
+func streamContent(w http.ResponseWriter, r *http.Request, f fs.File, info fs.FileInfo) {
+	if !info.ModTime().IsZero() {
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !info.ModTime().Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	contentType := mime.TypeByExtension(path.Ext(info.Name()))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, f)
+}
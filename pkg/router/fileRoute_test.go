@@ -0,0 +1,118 @@
+package router
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"warptail/pkg/utils"
+)
+
+// writeArchive zips the files into a temp dir, .bin entries are stored uncompressed
+func writeArchive(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "site.zip")
+	out, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	archive := zip.NewWriter(out)
+	for file, data := range files {
+		method := zip.Deflate
+		if filepath.Ext(file) == ".bin" {
+			method = zip.Store
+		}
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestFileRouteArchiveEntries(t *testing.T) {
+	large := bytes.Repeat([]byte("warptail "), maxBufferedEntry/8)
+	route := NewFileRoute(utils.RouteConfig{
+		Type: utils.FILES,
+		FileSettings: &utils.FileSettings{Archive: writeArchive(t, map[string][]byte{
+			"index.html": []byte("<h1>home</h1>"),
+			"stored.bin": []byte("0123456789"),
+			"large.txt":  large,
+		})},
+	})
+	if err := route.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer route.Stop()
+
+	tests := []struct {
+		path   string
+		rng    string
+		status int
+		body   []byte
+	}{
+		{path: "/", status: http.StatusOK, body: []byte("<h1>home</h1>")},
+		{path: "/stored.bin", rng: "bytes=2-4", status: http.StatusPartialContent, body: []byte("234")},
+		{path: "/large.txt", rng: "bytes=0-3", status: http.StatusOK, body: large},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if len(test.rng) > 0 {
+			req.Header.Set("Range", test.rng)
+		}
+		recorder := httptest.NewRecorder()
+		route.Handle(recorder, req)
+		if recorder.Code != test.status {
+			t.Fatalf("%s answered %d, want %d", test.path, recorder.Code, test.status)
+		}
+		if !bytes.Equal(recorder.Body.Bytes(), test.body) {
+			t.Fatalf("%s answered %d bytes, want %d", test.path, recorder.Body.Len(), len(test.body))
+		}
+	}
+}
+
+func TestUploadArchiveReplacesPrevious(t *testing.T) {
+	archivePath := utils.ArchivePath
+	utils.ArchivePath = t.TempDir()
+	t.Cleanup(func() { utils.ArchivePath = archivePath })
+	r := NewRouter()
+	svc := NewService(utils.ServiceConfig{
+		Name:    "site",
+		Enabled: true,
+		Routes:  []utils.RouteConfig{{Type: utils.FILES, Domain: "site.example.com", FileSettings: &utils.FileSettings{}}},
+	}, nil, RouteDeps{})
+	r.Services[svc.Id] = svc
+
+	if _, err := r.UploadArchive(svc.Id, 0, bytes.NewReader([]byte("not a zip"))); err == nil {
+		t.Fatal("uploading an invalid archive succeeded")
+	}
+
+	upload := func(body string) string {
+		data, err := os.ReadFile(writeArchive(t, map[string][]byte{"index.html": []byte(body)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.UploadArchive(svc.Id, 0, bytes.NewReader(data)); err != nil {
+			t.Fatal(err.Message)
+		}
+		recorder := httptest.NewRecorder()
+		svc.Routes[0].(HandlerRoute).Handle(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		if recorder.Body.String() != body {
+			t.Fatalf("route served %q, want %q", recorder.Body.String(), body)
+		}
+		return svc.Routes[0].Config().FileSettings.Archive
+	}
+	first := upload("first")
+	upload("second")
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("previous archive %s was kept", first)
+	}
+}
@@ -2,88 +2,318 @@ package router
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"warptail/pkg/utils"
+	"warptail/pkg/utils/logs"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/tsnet"
 )
 
+const (
+	defaultProxyTimeout    = 30 * time.Second
+	defaultIdleConnTimeout = 90 * time.Second
+)
+
 type HTTPRoute struct {
-	config   utils.RouteConfig
-	status   RouterStatus
-	data     *utils.TimeSeries
-	latency  time.Duration
-	heatbeat *time.Ticker
-	*http.Client
+	// mu guards the config, status, clients and tailnet server, clients are replaced
+	// as a whole when their limits change and never edited while in use
+	mu      sync.RWMutex
+	config  utils.RouteConfig
+	status  RouterStatus
+	data    *utils.TimeSeries
+	latency time.Duration
+
+	client          *http.Client
 	heartbeatClient *http.Client
+
+	websockets     sync.Map
+	websocketCount atomic.Int64
+
+	// Reverse routes serve their own listener on the tailnet node
+	ts            *tsnet.Server
+	tailnetServer *http.Server
+
+	deps RouteDeps
+}
+
+func NewHTTPRoute(config utils.RouteConfig, server *tsnet.Server, deps RouteDeps) *HTTPRoute {
+	client, heartbeatClient := newHTTPClients(config, server, deps)
+	return &HTTPRoute{
+		config:          config,
+		data:            utils.NewTimeSeries(time.Second, 1000),
+		status:          STOPPED,
+		client:          client,
+		heartbeatClient: heartbeatClient,
+		ts:              server,
+		deps:            deps,
+	}
 }
 
-func NewHTTPRoute(config utils.RouteConfig, server *tsnet.Server) *HTTPRoute {
-	client := server.HTTPClient()
+// newHTTPClients builds the proxy client with the limits from the route proxy
+// settings, and a separate heartbeat client to avoid affecting main traffic
+func newHTTPClients(config utils.RouteConfig, server *tsnet.Server, deps RouteDeps) (*http.Client, *http.Client) {
+	settings := utils.ProxySettings{}
+	if config.ProxySettings != nil {
+		settings = *config.ProxySettings
+	}
 
+	client := newHTTPClient(config, server, deps)
+	client.Timeout = defaultProxyTimeout
+	if settings.Timeout > 0 {
+		client.Timeout = time.Duration(settings.Timeout) * time.Second
+	}
 	// Configure optimized transport for connection pooling and keep-alive
 	if transport, ok := client.Transport.(*http.Transport); ok {
 		transport.MaxIdleConns = 100
 		transport.MaxIdleConnsPerHost = 20
-		transport.IdleConnTimeout = 90 * time.Second
 		transport.DisableKeepAlives = false
 		transport.ForceAttemptHTTP2 = true
 		transport.WriteBufferSize = 64 * 1024
 		transport.ReadBufferSize = 64 * 1024
+		transport.ResponseHeaderTimeout = time.Duration(settings.ResponseHeaderTimeout) * time.Second
+		transport.IdleConnTimeout = defaultIdleConnTimeout
+		if settings.IdleTimeout > 0 {
+			transport.IdleConnTimeout = time.Duration(settings.IdleTimeout) * time.Second
+		}
 	}
 
-	// Apply proxy settings timeout if configured
-	if config.ProxySettings != nil && config.ProxySettings.Timeout > 0 {
-		client.Timeout = time.Duration(config.ProxySettings.Timeout) * time.Second
-	}
-
-	// Create separate client for heartbeat to avoid affecting main traffic
-	heartbeatClient := server.HTTPClient()
+	heartbeatClient := newHTTPClient(config, server, deps)
 	heartbeatClient.Timeout = 5 * time.Second
+	return client, heartbeatClient
+}
 
-	return &HTTPRoute{
-		config:          config,
-		data:            utils.NewTimeSeries(time.Second, 1000),
-		status:          STOPPED,
-		Client:          client,
-		heartbeatClient: heartbeatClient,
+// newHTTPClient returns a client reaching the backend with the route dialer
+func newHTTPClient(config utils.RouteConfig, server *tsnet.Server, deps RouteDeps) *http.Client {
+	if config.DialsTailnet() && deps.dialer == nil {
+		return server.HTTPClient()
 	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.DialerType() != utils.DirectDialer || deps.dialer != nil {
+		transport.Proxy = nil
+		transport.DialContext = backendDialer(config, server, deps)
+	}
+	return &http.Client{Transport: transport}
 }
 
 func (route *HTTPRoute) Update(config utils.RouteConfig) error {
+	route.mu.Lock()
+	previous := route.config
 	route.config = config
+	var replaced *http.Client
+	if clientChanged(previous, config) {
+		replaced = route.client
+		route.client, route.heartbeatClient = newHTTPClients(config, route.ts, route.deps)
+	}
+	var err error
+	if route.status == RUNNING && tailnetListenerChanged(previous, config) {
+		if config.IsReverse() || config.IsTailnetExposed() {
+			err = route.listenTailnet()
+		} else {
+			route.closeTailnet()
+		}
+	}
+	route.mu.Unlock()
+	// requests in flight finish on the old client
+	if replaced != nil {
+		replaced.CloseIdleConnections()
+	}
+	return err
+}
 
-	// Update client timeout if proxy settings changed
-	if config.ProxySettings != nil && config.ProxySettings.Timeout > 0 {
-		route.Client.Timeout = time.Duration(config.ProxySettings.Timeout) * time.Second
-	} else {
-		// Reset to default timeout
-		route.Client.Timeout = 30 * time.Second
+// tailnetListenerChanged reports whether the listener on the tailnet node needs replacing
+func tailnetListenerChanged(previous, config utils.RouteConfig) bool {
+	if previous.IsReverse() != config.IsReverse() || previous.IsTailnetExposed() != config.IsTailnetExposed() {
+		return true
+	}
+	if config.IsReverse() {
+		return previous.ListenAddr()+previous.ListenNetwork() != config.ListenAddr()+config.ListenNetwork()
 	}
+	return config.IsTailnetExposed() && (previous.Expose != config.Expose || previous.ExposePort() != config.ExposePort())
+}
 
-	return nil
+// clientChanged reports whether the config changes the limits or the dialer of the clients
+func clientChanged(previous, config utils.RouteConfig) bool {
+	before, after := utils.ProxySettings{}, utils.ProxySettings{}
+	if previous.ProxySettings != nil {
+		before = *previous.ProxySettings
+	}
+	if config.ProxySettings != nil {
+		after = *config.ProxySettings
+	}
+	if before.Timeout != after.Timeout || before.ResponseHeaderTimeout != after.ResponseHeaderTimeout || before.IdleTimeout != after.IdleTimeout {
+		return true
+	}
+	// the transport dials through the dialer it was built with
+	return !reflect.DeepEqual(previous.Dialer, config.Dialer)
+}
+
+// clients returns the current proxy and heartbeat clients
+func (route *HTTPRoute) clients() (*http.Client, *http.Client) {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	return route.client, route.heartbeatClient
+}
+
+// IsStreaming reports whether the request should be streamed to and from the
+// backend without buffering or compression, either because the route is configured
+// for streaming or the client asked for server-sent events.
+func (route *HTTPRoute) IsStreaming(r *http.Request) bool {
+	config := route.Config()
+	if config.ProxySettings != nil && config.ProxySettings.Streaming {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// flushInterval returns the reverse proxy flush interval, streamed requests
+// flush after every write and others use the configured interval in milliseconds.
+func (route *HTTPRoute) flushInterval(streaming bool) time.Duration {
+	config := route.Config()
+	if streaming {
+		return -1
+	}
+	if config.ProxySettings != nil && config.ProxySettings.FlushInterval != 0 {
+		return time.Duration(config.ProxySettings.FlushInterval) * time.Millisecond
+	}
+	return 0
+}
+
+func (route *HTTPRoute) maxRequestBody() int64 {
+	config := route.Config()
+	if config.ProxySettings == nil {
+		return 0
+	}
+	return config.ProxySettings.MaxRequestBody
 }
 func (route *HTTPRoute) Start() error {
+	route.mu.Lock()
+	if route.status == RUNNING {
+		route.mu.Unlock()
+		return nil
+	}
+	if route.config.IsReverse() || route.config.IsTailnetExposed() {
+		if err := route.listenTailnet(); err != nil {
+			route.mu.Unlock()
+			return err
+		}
+	}
 	route.status = RUNNING
+	route.mu.Unlock()
 	go route.heartbeat(5 * time.Second)
 	return nil
 }
 func (route *HTTPRoute) Stop() error {
+	route.mu.Lock()
 	route.status = STOPPED
+	route.closeTailnet()
+	route.mu.Unlock()
+	route.closeWebSockets()
+	return nil
+}
+
+// closeTailnet and listenTailnet are called with mu held
+func (route *HTTPRoute) closeTailnet() {
+	if route.tailnetServer != nil {
+		route.tailnetServer.Close()
+		route.tailnetServer = nil
+	}
+}
+
+// listenTailnet serves a reverse route on its port of the tailnet node, or an
+// exposed https route through Tailscale Funnel or the tailnet TLS listener
+func (route *HTTPRoute) listenTailnet() error {
+	config := route.config
+	route.closeTailnet()
+	var (
+		listener net.Listener
+		handler  http.HandlerFunc
+		err      error
+	)
+	if config.IsReverse() {
+		listener, err = listenTCP(config, route.ts)
+		handler = route.serveTailnet
+	} else {
+		listener, err = listenExposed(config, route.ts)
+		handler = route.serveExposed
+	}
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	route.tailnetServer = srv
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			utils.Logger.Error(err, "tailnet http route stopped", "domain", config.Domain, "port", config.Port)
+		}
+	}()
 	return nil
 }
 
+// serveTailnet handles requests from tailnet clients, logging them like proxied requests
+func (route *HTTPRoute) serveTailnet(w http.ResponseWriter, r *http.Request) {
+	hrw := &logs.HttpResponseWriter{ResponseWriter: w, StatusCode: http.StatusOK}
+	start := time.Now()
+	config := route.Config()
+
+	var who *apitype.WhoIsResponse
+	if config.TailscaleIdentity != nil || config.Private {
+		var err error
+		if who, err = WhoIs(r.Context(), route.ts, r.RemoteAddr); err != nil {
+			utils.Logger.V(1).Info("tailscale whois failed", "remote", r.RemoteAddr, "error", err.Error())
+		}
+	}
+	if config.TailscaleIdentity != nil {
+		if config.TailscaleIdentity.Headers {
+			ApplyIdentityHeaders(r.Header, who)
+		} else {
+			StripIdentityHeaders(r.Header)
+		}
+	}
+
+	// There is no warptail login on the tailnet listener, private routes rely on the tailnet identity
+	if config.Private && !IdentityAllowed(config.TailscaleIdentity, who) {
+		http.Error(hrw, "Forbidden", http.StatusForbidden)
+	} else {
+		route.Handle(hrw, r)
+	}
+
+	if utils.RequestLogger == nil {
+		return
+	}
+	entry := logs.NewAccessEntry(r, start, hrw.StatusCode, hrw.Size)
+	entry.Upstream = Upstream(config)
+	entry.Route = r.Host
+	entry.User, _ = IdentityLogin(who)
+	utils.RequestLogger.LogAccess(r, entry, config.AccessLog)
+}
+
 func (route *HTTPRoute) Status() RouterStatus {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
 	return route.status
 }
 
 func (route *HTTPRoute) Config() utils.RouteConfig {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
 	return route.config
 }
 
@@ -92,26 +322,28 @@ func (route *HTTPRoute) Stats() utils.TimeSeriesData {
 }
 
 func (route *HTTPRoute) getUrl() (*url.URL, error) {
-	return url.Parse(fmt.Sprintf("http://%s:%d", route.config.Machine.Address, route.config.Machine.Port))
+	config := route.Config()
+	return url.Parse("http://" + net.JoinHostPort(machineHost(config, route.ts), strconv.Itoa(int(config.Machine.Port))))
 }
 
 func (route *HTTPRoute) getTargetUrl(requestPath string) (*url.URL, string, bool) {
+	config := route.Config()
 	// Check for path-based routing rules
-	if route.config.ProxySettings != nil && len(route.config.ProxySettings.Rules) > 0 {
-		for _, rule := range route.config.ProxySettings.Rules {
+	if config.ProxySettings != nil && len(config.ProxySettings.Rules) > 0 {
+		for _, rule := range config.ProxySettings.Rules {
 			if strings.HasPrefix(requestPath, rule.Path) {
 				targetHost := rule.TargetHost
 				targetPort := rule.TargetPort
 
 				// Use default machine if not specified in rule
 				if targetHost == "" {
-					targetHost = route.config.Machine.Address
+					targetHost = machineHost(config, route.ts)
 				}
 				if targetPort == 0 {
-					targetPort = int(route.config.Machine.Port)
+					targetPort = int(config.Machine.Port)
 				}
 
-				targetUrl, err := url.Parse(fmt.Sprintf("http://%s:%d", targetHost, targetPort))
+				targetUrl, err := url.Parse("http://" + net.JoinHostPort(targetHost, strconv.Itoa(targetPort)))
 				if err != nil {
 					continue
 				}
@@ -139,7 +371,8 @@ func (route *HTTPRoute) getTargetUrl(requestPath string) (*url.URL, string, bool
 }
 
 func (route *HTTPRoute) Handle(w http.ResponseWriter, r *http.Request) {
-	if route.status != RUNNING {
+	config := route.Config()
+	if route.Status() != RUNNING {
 		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
@@ -150,13 +383,31 @@ func (route *HTTPRoute) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if bodyBytes, err := io.ReadAll(r.Body); err == nil {
+	if limit := route.maxRequestBody(); limit > 0 {
+		if r.ContentLength > limit {
+			writeLimitError(w, r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body of %d bytes exceeds limit of %d bytes", r.ContentLength, limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	streaming := route.IsStreaming(r)
+	if streaming {
+		r.Body = &countingBody{ReadCloser: r.Body, data: route.data}
+	} else {
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
 		route.data.LogSent(uint64(len(bodyBytes)))
 		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(targetUrl)
-	proxy.Transport = route.Transport
+	client, _ := route.clients()
+	proxy.Transport = client.Transport
+	proxy.FlushInterval = route.flushInterval(streaming)
 
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
@@ -166,17 +417,17 @@ func (route *HTTPRoute) Handle(w http.ResponseWriter, r *http.Request) {
 		req.URL.Path = rewritePath
 
 		// Handle proxy settings
-		if route.config.ProxySettings != nil {
+		if config.ProxySettings != nil {
 			// Preserve or modify host header
-			if route.config.ProxySettings.PreserveHost {
+			if config.ProxySettings.PreserveHost {
 				req.Host = r.Host
 			} else {
 				req.Host = targetUrl.Host
 			}
 
 			// Apply custom headers
-			if route.config.ProxySettings.CustomHeaders != nil {
-				headers := route.config.ProxySettings.CustomHeaders
+			if config.ProxySettings.CustomHeaders != nil {
+				headers := config.ProxySettings.CustomHeaders
 
 				// Remove headers
 				for _, headerName := range headers.Remove {
@@ -214,8 +465,8 @@ func (route *HTTPRoute) Handle(w http.ResponseWriter, r *http.Request) {
 
 	proxy.ModifyResponse = func(resp *http.Response) error {
 		// Apply response header modifications if configured
-		if route.config.ProxySettings != nil && route.config.ProxySettings.CustomHeaders != nil {
-			headers := route.config.ProxySettings.CustomHeaders
+		if config.ProxySettings != nil && config.ProxySettings.CustomHeaders != nil {
+			headers := config.ProxySettings.CustomHeaders
 
 			// Remove response headers
 			for _, headerName := range headers.Remove {
@@ -243,6 +494,15 @@ func (route *HTTPRoute) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeBodyError(w, r, err)
+			return
+		}
+		if isTimeout(err) {
+			writeLimitError(w, r, http.StatusGatewayTimeout, fmt.Errorf("proxy timeout to %s: %v", targetUrl.String(), err))
+			return
+		}
 		// Log to error log
 		if utils.RequestLogger != nil {
 			utils.RequestLogger.LogError(r, fmt.Errorf("proxy error to %s: %v", targetUrl.String(), err))
@@ -251,21 +511,20 @@ func (route *HTTPRoute) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rr := NewResponseRecorder(w)
-	proxy.ServeHTTP(rr, r)
-	if utils.RequestLogger != nil {
-		utils.RequestLogger.LogRequest(r, time.Now(), rr.statusCode, rr.responseSize)
+	if isWebSocketRequest(r) {
+		rr.onHijack = route.trackWebSocket
 	}
+	proxy.ServeHTTP(rr, r)
 	route.data.LogRecived(uint64(rr.responseSize))
 }
 
 func (route *HTTPRoute) heartbeat(timeout time.Duration) {
-	route.heatbeat = time.NewTicker(timeout)
+	ticker := time.NewTicker(timeout)
 	go func() {
-		for range route.heatbeat.C {
-			if route.status != RUNNING {
+		for range ticker.C {
+			if route.Status() != RUNNING {
 				route.latency = time.Duration(-1)
-				route.heatbeat.Stop()
-				route.heatbeat = nil
+				ticker.Stop()
 				return
 			}
 			start := time.Now()
@@ -275,7 +534,8 @@ func (route *HTTPRoute) heartbeat(timeout time.Duration) {
 				continue
 			}
 			// Use dedicated heartbeat client to avoid affecting main traffic
-			resp, err := route.heartbeatClient.Get(url.String())
+			_, heartbeatClient := route.clients()
+			resp, err := heartbeatClient.Get(url.String())
 			if err != nil {
 				utils.Logger.Error(err, "Error pinging server", "url", url.String())
 				route.latency = time.Duration(-1)
@@ -290,3 +550,45 @@ func (route *HTTPRoute) heartbeat(timeout time.Duration) {
 func (route *HTTPRoute) Ping() time.Duration {
 	return route.latency
 }
+
+// writeBodyError maps a failure reading the request body to the matching client error
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeLimitError(w, r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds limit of %d bytes", maxBytesErr.Limit))
+	case isTimeout(err):
+		writeLimitError(w, r, http.StatusRequestTimeout, fmt.Errorf("timeout reading request body: %v", err))
+	default:
+		writeLimitError(w, r, http.StatusBadRequest, fmt.Errorf("unable to read request body: %v", err))
+	}
+}
+
+func writeLimitError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if utils.RequestLogger != nil {
+		utils.RequestLogger.LogError(r, err)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// countingBody logs request bytes as they are streamed to the backend
+type countingBody struct {
+	io.ReadCloser
+	data *utils.TimeSeries
+}
+
+func (body *countingBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	if n > 0 {
+		body.data.LogSent(uint64(n))
+	}
+	return n, err
+}
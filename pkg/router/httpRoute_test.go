@@ -0,0 +1,97 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"warptail/pkg/utils"
+)
+
+// Updating the limits swaps the transport while requests are proxied, run with -race
+func TestHTTPRouteUpdateLimits(t *testing.T) {
+	network := newMemoryNetwork()
+	backend, err := network.Listen("backend:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})}
+	go server.Serve(backend)
+	defer server.Close()
+
+	config := memoryRoute(utils.HTTP, 8080)
+	route := NewHTTPRoute(config, nil, RouteDeps{dialer: network})
+	if err := route.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer route.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			recorder := httptest.NewRecorder()
+			route.Handle(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if recorder.Code != http.StatusOK {
+				t.Errorf("request %d answered %d", i, recorder.Code)
+				return
+			}
+		}
+	}()
+	for i := 1; i <= 20; i++ {
+		config.ProxySettings = &utils.ProxySettings{ResponseHeaderTimeout: i, IdleTimeout: i}
+		route.Update(config)
+	}
+	wg.Wait()
+
+	client, _ := route.clients()
+	if timeout := client.Transport.(*http.Transport).ResponseHeaderTimeout; timeout != 20*time.Second {
+		t.Fatalf("transport response header timeout is %s, want 20s", timeout)
+	}
+}
+
+// Status is read by the api while the route is restarted, run with -race
+func TestHTTPRouteRestart(t *testing.T) {
+	route := NewHTTPRoute(memoryRoute(utils.HTTP, 8080), nil, RouteDeps{dialer: newMemoryNetwork()})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			route.Status()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := route.Start(); err != nil {
+			t.Fatal(err)
+		}
+		route.Stop()
+	}
+	<-done
+	if status := route.Status(); status != STOPPED {
+		t.Fatalf("route is %s after stop", status)
+	}
+}
+
+// A new dialer replaces the client, the old transport would keep dialing the previous backend path
+func TestHTTPRouteUpdateDialer(t *testing.T) {
+	config := memoryRoute(utils.HTTP, 8080)
+	route := NewHTTPRoute(config, nil, RouteDeps{dialer: newMemoryNetwork()})
+	before, _ := route.clients()
+
+	config.Dialer = &utils.DialerSettings{Type: utils.SOCKS5Dialer, Proxy: "proxy:1080"}
+	route.Update(config)
+	after, _ := route.clients()
+	if before == after {
+		t.Fatal("changing the dialer kept the previous client")
+	}
+
+	route.Update(config)
+	if same, _ := route.clients(); same != after {
+		t.Fatal("an unchanged dialer replaced the client")
+	}
+}
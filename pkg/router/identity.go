@@ -0,0 +1,105 @@
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+	"warptail/pkg/utils"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tsnet"
+)
+
+// Headers sent to the backend describing the tailnet identity of the client
+const (
+	TailscaleUserLogin      = "Tailscale-User-Login"
+	TailscaleUserName       = "Tailscale-User-Name"
+	TailscaleUserProfilePic = "Tailscale-User-Profile-Pic"
+	TailscaleNodeName       = "Tailscale-Node-Name"
+	TailscaleNodeTags       = "Tailscale-Node-Tags"
+)
+
+var identityHeaders = []string{TailscaleUserLogin, TailscaleUserName, TailscaleUserProfilePic, TailscaleNodeName, TailscaleNodeTags}
+
+// WhoIs returns the tailnet identity behind remoteAddr, nil when the address is
+// not a Tailscale IP. remoteAddr must be the connection peer, never a forwarded header.
+func WhoIs(ctx context.Context, ts *tsnet.Server, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !tsaddr.IsTailscaleIP(ip.Unmap()) || ts == nil {
+		return nil, nil
+	}
+	client, err := ts.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return client.WhoIs(ctx, remoteAddr)
+}
+
+// WhoIs returns the tailnet identity behind remoteAddr using the node of the named tailnet
+func (r *Router) WhoIs(ctx context.Context, tailnet string, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	return WhoIs(ctx, r.server(tailnet), remoteAddr)
+}
+
+// StripIdentityHeaders removes client supplied identity headers, the backend of a
+// route with a tailscale identity must only see the ones set by warptail.
+func StripIdentityHeaders(header http.Header) {
+	for _, name := range identityHeaders {
+		header.Del(name)
+	}
+}
+
+// ApplyIdentityHeaders replaces any client supplied identity headers with the
+// tailnet identity of the request, who may be nil for clients off the tailnet.
+func ApplyIdentityHeaders(header http.Header, who *apitype.WhoIsResponse) {
+	StripIdentityHeaders(header)
+	if who == nil {
+		return
+	}
+	if who.UserProfile != nil && (who.Node == nil || !who.Node.IsTagged()) {
+		header.Set(TailscaleUserLogin, who.UserProfile.LoginName)
+		header.Set(TailscaleUserName, who.UserProfile.DisplayName)
+		if len(who.UserProfile.ProfilePicURL) > 0 {
+			header.Set(TailscaleUserProfilePic, who.UserProfile.ProfilePicURL)
+		}
+	}
+	if who.Node != nil {
+		header.Set(TailscaleNodeName, strings.TrimSuffix(who.Node.Name, "."))
+		if who.Node.IsTagged() {
+			header.Set(TailscaleNodeTags, strings.Join(who.Node.Tags, ","))
+		}
+	}
+}
+
+// IdentityLogin returns the user login of a tailnet identity along with the node
+// tags, tagged nodes have no user and are matched on their tags only.
+func IdentityLogin(who *apitype.WhoIsResponse) (string, []string) {
+	if who == nil || who.Node == nil {
+		return "", nil
+	}
+	if who.Node.IsTagged() || who.UserProfile == nil {
+		return "", who.Node.Tags
+	}
+	return who.UserProfile.LoginName, nil
+}
+
+// IdentityAllowed reports whether the tailnet identity may use a private route
+func IdentityAllowed(settings *utils.TailscaleIdentitySettings, who *apitype.WhoIsResponse) bool {
+	if settings == nil || !settings.Authenticate || who == nil {
+		return false
+	}
+	login, tags := IdentityLogin(who)
+	if len(login) == 0 && len(tags) == 0 {
+		return false
+	}
+	return settings.Allows(login, tags)
+}
@@ -0,0 +1,201 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"warptail/pkg/utils"
+)
+
+// memoryNetwork is an in process network, routes built with it as their dialer
+// reach the listeners registered here so tests need no tailnet or backend sockets
+type memoryNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+func newMemoryNetwork() *memoryNetwork {
+	return &memoryNetwork{listeners: map[string]*memoryListener{}}
+}
+
+// Listen registers a listener for addr, the backend address of routes under test
+func (network *memoryNetwork) Listen(addr string) (net.Listener, error) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	if _, ok := network.listeners[addr]; ok {
+		return nil, fmt.Errorf("memory listener %s already in use", addr)
+	}
+	listener := &memoryListener{
+		network: network,
+		addr:    memoryAddr(addr),
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+	network.listeners[addr] = listener
+	return listener, nil
+}
+
+// DialContext connects to a registered listener over a synchronous in memory pipe
+func (network *memoryNetwork) DialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	network.mu.Lock()
+	listener, ok := network.listeners[addr]
+	network.mu.Unlock()
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memoryAddr(addr), Err: fmt.Errorf("connection refused")}
+	}
+	client, server := net.Pipe()
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.closed:
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memoryAddr(addr), Err: fmt.Errorf("connection refused")}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type memoryAddr string
+
+func (addr memoryAddr) Network() string { return "memory" }
+func (addr memoryAddr) String() string  { return string(addr) }
+
+type memoryListener struct {
+	network *memoryNetwork
+	addr    memoryAddr
+	conns   chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (listener *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *memoryListener) Close() error {
+	listener.once.Do(func() {
+		close(listener.closed)
+		listener.network.mu.Lock()
+		delete(listener.network.listeners, string(listener.addr))
+		listener.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (listener *memoryListener) Addr() net.Addr {
+	return listener.addr
+}
+
+// memoryRoute is a route config listening on loopback and dialing backend:port,
+// routes built with a memory network reach it without a tailnet
+func memoryRoute(routeType utils.RouteType, port uint16) utils.RouteConfig {
+	return utils.RouteConfig{
+		Type:          routeType,
+		ListenAddress: "127.0.0.1",
+		Machine:       utils.Machine{Address: "backend", Port: port},
+	}
+}
+
+// echo accepts connections on the listener and writes back what it reads
+func echo(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+func TestTCPRouteMemoryBackend(t *testing.T) {
+	network := newMemoryNetwork()
+	backend, err := network.Listen("backend:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go echo(backend)
+
+	route := NewTCPRoute(memoryRoute(utils.TCP, 7000), nil, RouteDeps{dialer: network})
+	if err := route.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer route.Stop()
+	route.mu.RLock()
+	addr := route.listener.Addr().String()
+	route.mu.RUnlock()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Fatalf("backend echoed %q, want %q", line, "hello\n")
+	}
+}
+
+func TestHTTPRouteMemoryBackend(t *testing.T) {
+	network := newMemoryNetwork()
+	backend, err := network.Listen("backend:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "path=%s", r.URL.Path)
+	})}
+	go server.Serve(backend)
+	defer server.Close()
+
+	route := NewHTTPRoute(memoryRoute(utils.HTTP, 8080), nil, RouteDeps{dialer: network})
+	if err := route.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer route.Stop()
+
+	recorder := httptest.NewRecorder()
+	route.Handle(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/status", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("route answered %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+	if body := recorder.Body.String(); body != "path=/status" {
+		t.Fatalf("backend answered %q, want %q", body, "path=/status")
+	}
+}
+
+// A backend nobody listens on is reported as down, not as blocked
+func TestDiagnoseMemoryBackendDown(t *testing.T) {
+	network := newMemoryNetwork()
+	check := dialCheck(context.Background(), network, "backend:9000")
+	if check.OK || !check.refused {
+		t.Fatalf("dial to a closed backend returned %+v, want refused", check)
+	}
+	diagnostic := RouteDiagnostic{
+		Disco: DiagnosticCheck{OK: true},
+		TSMP:  DiagnosticCheck{OK: true},
+		Dial:  check,
+	}.conclude()
+	if diagnostic.Result != DiagnosticBackendDown {
+		t.Fatalf("concluded %s, want %s", diagnostic.Result, DiagnosticBackendDown)
+	}
+}
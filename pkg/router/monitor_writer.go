@@ -2,7 +2,6 @@ package router
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"net"
 	"net/http"
@@ -56,14 +55,13 @@ type ResponseRecorder struct {
 	http.ResponseWriter
 	statusCode   int
 	responseSize int
-	body         *bytes.Buffer
+	onHijack     func(net.Conn) net.Conn
 }
 
 func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
 	return &ResponseRecorder{
 		ResponseWriter: w,
 		statusCode:     http.StatusOK,
-		body:           bytes.NewBuffer(nil),
 	}
 }
 
@@ -74,7 +72,12 @@ func (rr *ResponseRecorder) WriteHeader(statusCode int) {
 
 func (rr *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hj, ok := rr.ResponseWriter.(http.Hijacker); ok {
-		return hj.Hijack()
+		conn, rw, err := hj.Hijack()
+		if err != nil || rr.onHijack == nil {
+			return conn, rw, err
+		}
+		rr.statusCode = http.StatusSwitchingProtocols
+		return rr.onHijack(conn), rw, nil
 	}
 	return nil, nil, errors.New("ResponseWriter does not support hijacking")
 }
@@ -84,6 +87,15 @@ func (rr *ResponseRecorder) Write(b []byte) (int, error) {
 	if err == nil {
 		rr.responseSize += size
 	}
-	rr.body.Write(b)
 	return size, err
 }
+
+// Flush sends any buffered data to the client so streamed responses are not held back.
+func (rr *ResponseRecorder) Flush() {
+	http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rr *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
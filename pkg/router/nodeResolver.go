@@ -0,0 +1,219 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"warptail/pkg/utils"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tsnet"
+)
+
+const nodeCacheTTL = time.Minute
+
+// pathCacheTTL is shorter as the path to a peer changes without a netmap update
+const pathCacheTTL = 10 * time.Second
+
+// resolvers holds one nodeResolver per tsnet server, entries are removed with
+// forgetResolver when the server is closed or replaced
+var resolvers sync.Map
+
+// nodeResolver maps Tailscale node names to their tailnet address and keeps the
+// subnet routes peers advertise and the path to each peer. The cache is built
+// from the LocalClient status in the background, lookups never wait on it.
+type nodeResolver struct {
+	ts     *tsnet.Server
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.RWMutex
+	nodes      map[string]string
+	subnets    []advertisedRoute
+	paths      map[string]TailnetPath
+	updated    time.Time
+	watching   bool
+	refreshing bool
+	pending    bool
+}
+
+func resolverFor(ts *tsnet.Server) *nodeResolver {
+	if resolver, ok := resolvers.Load(ts); ok {
+		return resolver.(*nodeResolver)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	resolver, loaded := resolvers.LoadOrStore(ts, &nodeResolver{ts: ts, ctx: ctx, cancel: cancel})
+	if loaded {
+		cancel()
+	}
+	return resolver.(*nodeResolver)
+}
+
+// forgetResolver drops the cache of a closed or replaced server and stops its watcher
+func forgetResolver(ts *tsnet.Server) {
+	if ts == nil {
+		return
+	}
+	if resolver, ok := resolvers.LoadAndDelete(ts); ok {
+		resolver.(*nodeResolver).cancel()
+	}
+}
+
+// resolve returns the tailnet address of a node by hostname or MagicDNS name
+func (resolver *nodeResolver) resolve(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	resolver.ensureFresh(nodeCacheTTL)
+	resolver.mu.RLock()
+	defer resolver.mu.RUnlock()
+	addr, ok := resolver.nodes[name]
+	return addr, ok
+}
+
+// ensureFresh starts a background refresh when the cache was never loaded or is
+// older than ttl, callers keep reading the previous entries meanwhile
+func (resolver *nodeResolver) ensureFresh(ttl time.Duration) {
+	resolver.mu.RLock()
+	fresh := resolver.nodes != nil && time.Since(resolver.updated) < ttl
+	resolver.mu.RUnlock()
+	if !fresh {
+		resolver.refreshAsync()
+	}
+}
+
+// refreshAsync refreshes the cache in a single goroutine per resolver, a request
+// arriving while one runs is folded into one more pass once it completes
+func (resolver *nodeResolver) refreshAsync() {
+	resolver.mu.Lock()
+	if resolver.refreshing {
+		resolver.pending = true
+		resolver.mu.Unlock()
+		return
+	}
+	resolver.refreshing = true
+	resolver.mu.Unlock()
+
+	go func() {
+		for {
+			if err := resolver.refresh(); err != nil {
+				utils.Logger.V(1).Info("unable to load tailscale nodes", "error", err.Error())
+			}
+			resolver.mu.Lock()
+			if !resolver.pending || resolver.ctx.Err() != nil {
+				resolver.refreshing = false
+				resolver.pending = false
+				resolver.mu.Unlock()
+				return
+			}
+			resolver.pending = false
+			resolver.mu.Unlock()
+		}
+	}()
+}
+
+func (resolver *nodeResolver) refresh() error {
+	client, err := resolver.ts.LocalClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(resolver.ctx, 5*time.Second)
+	defer cancel()
+	status, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	nodes := map[string]string{}
+	subnets := []advertisedRoute{}
+	paths := map[string]TailnetPath{}
+	for _, peer := range status.Peer {
+		subnets = append(subnets, peerRoutes(peer)...)
+		path := peerPath(peer)
+		for _, ip := range peer.TailscaleIPs {
+			paths[ip.String()] = path
+		}
+	}
+	peers := []*ipnstate.PeerStatus{}
+	if status.Self != nil {
+		peers = append(peers, status.Self)
+	}
+	for _, peer := range status.Peer {
+		peers = append(peers, peer)
+	}
+	for _, peer := range peers {
+		if len(peer.TailscaleIPs) == 0 {
+			continue
+		}
+		ip := peer.TailscaleIPs[0]
+		for _, addr := range peer.TailscaleIPs {
+			if addr.Is4() {
+				ip = addr
+				break
+			}
+		}
+		dnsName := strings.TrimSuffix(strings.ToLower(peer.DNSName), ".")
+		nodes[strings.ToLower(peer.HostName)] = ip.String()
+		if len(dnsName) > 0 {
+			nodes[dnsName] = ip.String()
+			nodes[strings.Split(dnsName, ".")[0]] = ip.String()
+		}
+	}
+
+	resolver.mu.Lock()
+	resolver.nodes = nodes
+	resolver.subnets = subnets
+	resolver.paths = paths
+	resolver.updated = time.Now()
+	startWatch := !resolver.watching && resolver.ctx.Err() == nil
+	resolver.watching = true
+	resolver.mu.Unlock()
+
+	if startWatch {
+		go resolver.watch()
+	}
+	return nil
+}
+
+// watch reloads the cache on every netmap change so moved nodes are re-resolved
+func (resolver *nodeResolver) watch() {
+	defer func() {
+		resolver.mu.Lock()
+		resolver.watching = false
+		resolver.mu.Unlock()
+	}()
+	client, err := resolver.ts.LocalClient()
+	if err != nil {
+		return
+	}
+	watcher, err := client.WatchIPNBus(resolver.ctx, ipn.NotifyRateLimit)
+	if err != nil {
+		return
+	}
+	defer watcher.Close()
+	for {
+		notify, err := watcher.Next()
+		if err != nil {
+			return
+		}
+		if notify.NetMap != nil {
+			resolver.refreshAsync()
+		}
+	}
+}
+
+// machineHost returns the host a route dials, resolving the node name of forward
+// routes and falling back to the configured address.
+func machineHost(config utils.RouteConfig, ts *tsnet.Server) string {
+	machine := config.Machine
+	if len(machine.NodeName) == 0 || !config.DialsTailnet() || ts == nil {
+		return machine.Address
+	}
+	if addr, ok := resolverFor(ts).resolve(machine.NodeName); ok {
+		return addr
+	}
+	if len(machine.Address) > 0 {
+		return machine.Address
+	}
+	return machine.NodeName
+}
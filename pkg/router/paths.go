@@ -0,0 +1,95 @@
+package router
+
+import (
+	"net/netip"
+	"warptail/pkg/utils"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tsnet"
+)
+
+// TailnetPath is how traffic to the peer behind a route travels, straight to
+// one of its endpoints, through a peer relay or relayed by a DERP region
+type TailnetPath struct {
+	Peer       string `json:"peer"`
+	Path       string `json:"path"`
+	Endpoint   string `json:"endpoint,omitempty"`
+	DERPRegion string `json:"derp_region,omitempty"`
+	Active     bool   `json:"active"`
+}
+
+// peerPath reads the current path from the peer status, peers without a
+// direct endpoint or peer relay talk through their home DERP region
+func peerPath(peer *ipnstate.PeerStatus) TailnetPath {
+	path := TailnetPath{
+		Peer:       peer.HostName,
+		DERPRegion: peer.Relay,
+		Active:     peer.Active,
+	}
+	switch {
+	case len(peer.CurAddr) > 0:
+		path.Path = PathDirect
+		path.Endpoint = peer.CurAddr
+		path.DERPRegion = ""
+	case len(peer.PeerRelay) > 0:
+		path.Path = PathPeerRelay
+		path.Endpoint = peer.PeerRelay
+		path.DERPRegion = ""
+	case len(peer.Relay) > 0:
+		path.Path = PathDERP
+	}
+	return path
+}
+
+// path returns the path to the peer with the tailnet address addr
+func (resolver *nodeResolver) path(addr string) (TailnetPath, bool) {
+	resolver.ensureFresh(pathCacheTTL)
+	resolver.mu.RLock()
+	defer resolver.mu.RUnlock()
+	path, ok := resolver.paths[addr]
+	return path, ok
+}
+
+// tailnetPathFor returns the path to the peer carrying the backend of a route,
+// the backend node itself or the subnet router advertising its address. It is
+// nil for routes not dialing through the tailnet or peers that are unknown.
+func tailnetPathFor(config utils.RouteConfig, ts *tsnet.Server) *TailnetPath {
+	if ts == nil || !config.DialsTailnet() {
+		return nil
+	}
+	host := machineHost(config, ts)
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil
+	}
+	resolver := resolverFor(ts)
+	peerIP := addr.String()
+	if !tsaddr.IsTailscaleIP(addr) {
+		route, ok := resolver.subnetRoute(addr.Unmap())
+		if !ok || len(route.peerIP) == 0 {
+			return nil
+		}
+		peerIP = route.peerIP
+	}
+	if path, ok := resolver.path(peerIP); ok {
+		return &path
+	}
+	return nil
+}
+
+func (route *HTTPRoute) TailnetPath() *TailnetPath {
+	return tailnetPathFor(route.Config(), route.ts)
+}
+
+func (route *TCPRoute) TailnetPath() *TailnetPath {
+	return tailnetPathFor(route.Config(), route.client)
+}
+
+func (route *UDPRoute) TailnetPath() *TailnetPath {
+	return tailnetPathFor(route.Config(), route.client)
+}
+
+func (route *PortRangeRoute) TailnetPath() *TailnetPath {
+	return tailnetPathFor(route.Config(), route.server)
+}
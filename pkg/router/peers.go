@@ -0,0 +1,160 @@
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"warptail/pkg/utils"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/ipn/ipnstate"
+)
+
+const (
+	probeTimeout     = 2 * time.Second
+	probeConcurrency = 8
+)
+
+// ProbePort is a commonly used port checked when probing a peer
+type ProbePort struct {
+	Port    int             `json:"port"`
+	Service string          `json:"service"`
+	Type    utils.RouteType `json:"type"`
+}
+
+// CommonPorts are the ports probed on a peer to suggest service targets
+var CommonPorts = []ProbePort{
+	{Port: 22, Service: "ssh", Type: utils.TCP},
+	{Port: 53, Service: "dns", Type: utils.UDP},
+	{Port: 80, Service: "http", Type: utils.HTTP},
+	{Port: 443, Service: "https", Type: utils.TLS_PASSTHROUGH},
+	{Port: 1883, Service: "mqtt", Type: utils.TCP},
+	{Port: 3000, Service: "http", Type: utils.HTTP},
+	{Port: 3306, Service: "mysql", Type: utils.TCP},
+	{Port: 5000, Service: "http", Type: utils.HTTP},
+	{Port: 5432, Service: "postgres", Type: utils.TCP},
+	{Port: 6379, Service: "redis", Type: utils.TCP},
+	{Port: 8000, Service: "http", Type: utils.HTTP},
+	{Port: 8080, Service: "http", Type: utils.HTTP},
+	{Port: 8443, Service: "https", Type: utils.TLS_PASSTHROUGH},
+	{Port: 9000, Service: "http", Type: utils.HTTP},
+	{Port: 9090, Service: "http", Type: utils.HTTP},
+	{Port: 25565, Service: "minecraft", Type: utils.TCP},
+	{Port: 32400, Service: "plex", Type: utils.HTTP},
+}
+
+// probeLimiter bounds port probes across all callers so the API cannot be used
+// to scan the tailnet
+var probeLimiter = rate.NewLimiter(rate.Every(20*time.Second), 3)
+
+// peerInfo converts the LocalClient view of a peer
+func peerInfo(peer *ipnstate.PeerStatus) TailscalePeers {
+	info := TailscalePeers{
+		Id:             string(peer.ID),
+		Name:           peer.DNSName,
+		HostName:       peer.HostName,
+		IPs:            []string{},
+		LastSeen:       peer.LastSeen,
+		Online:         peer.Online,
+		Os:             peer.OS,
+		ExitNode:       peer.ExitNode,
+		ExitNodeOption: peer.ExitNodeOption,
+	}
+	for _, ip := range peer.TailscaleIPs {
+		info.IPs = append(info.IPs, ip.String())
+		if len(info.IP) == 0 || (ip.Is4() && strings.Contains(info.IP, ":")) {
+			info.IP = ip.String()
+		}
+	}
+	if peer.Tags != nil {
+		info.Tags = peer.Tags.AsSlice()
+	}
+	if peer.KeyExpiry != nil {
+		info.KeyExpiry = *peer.KeyExpiry
+	}
+	return info
+}
+
+// findPeer returns the peer with the given hostname, MagicDNS name or tailnet IP
+func findPeer(status *ipnstate.Status, node string) *ipnstate.PeerStatus {
+	node = strings.TrimSuffix(strings.ToLower(node), ".")
+	for _, peer := range status.Peer {
+		dnsName := strings.TrimSuffix(strings.ToLower(peer.DNSName), ".")
+		if strings.EqualFold(peer.HostName, node) || dnsName == node || strings.Split(dnsName, ".")[0] == node {
+			return peer
+		}
+		for _, ip := range peer.TailscaleIPs {
+			if ip.String() == node {
+				return peer
+			}
+		}
+	}
+	return nil
+}
+
+// ProbePeer checks the common ports of a tailnet peer and returns those accepting
+// connections. Only peers of the tailnet can be probed and probes are rate limited.
+func (r *Router) ProbePeer(ctx context.Context, tailnet string, node string) ([]ProbePort, *utils.RouterError) {
+	ts := r.server(tailnet)
+	if ts == nil {
+		return nil, utils.CustomError(http.StatusInternalServerError, "unable to get tailscale status")
+	}
+	client, err := ts.LocalClient()
+	if err != nil {
+		return nil, utils.CustomError(http.StatusInternalServerError, "unable to get tailscale status")
+	}
+	status, err := client.Status(ctx)
+	if err != nil {
+		return nil, utils.CustomError(http.StatusInternalServerError, "unable to get tailscale status")
+	}
+	peer := findPeer(status, node)
+	if peer == nil || len(peer.TailscaleIPs) == 0 {
+		return nil, utils.NotFoundError("tailscale node not found")
+	}
+	if !peer.Online {
+		return nil, utils.BadReqError("tailscale node is offline")
+	}
+	if !probeLimiter.Allow() {
+		return nil, utils.CustomError(http.StatusTooManyRequests, "port probe rate limit exceeded, try again later")
+	}
+
+	host := peerInfo(peer).IP
+	open := make([]bool, len(CommonPorts))
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for i, port := range CommonPorts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			open[i] = probePort(ctx, ts.Dial, host, port)
+		}()
+	}
+	wg.Wait()
+
+	ports := []ProbePort{}
+	for i, port := range CommonPorts {
+		if open[i] {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// probePort reports whether the port accepts connections, UDP ports cannot be
+// told apart from filtered ones and are only reported for DNS which answers TCP too
+func probePort(ctx context.Context, dial dialFunc, host string, port ProbePort) bool {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	conn, err := dial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port.Port)))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
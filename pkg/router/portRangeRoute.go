@@ -0,0 +1,248 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"warptail/pkg/utils"
+
+	"tailscale.com/tsnet"
+)
+
+// portRoute is implemented by the TCP and UDP routes a PortRangeRoute is built from
+type portRoute interface {
+	Route
+	setData(data *utils.TimeSeries)
+	disableHeartbeat()
+	measureLatency()
+}
+
+// PortRangeRoute listens on a range of ports, each port is forwarded 1:1 to the
+// matching backend port by its own TCP or UDP route sharing one set of stats.
+type PortRangeRoute struct {
+	config utils.RouteConfig
+	server *tsnet.Server
+	deps   RouteDeps
+	data   *utils.TimeSeries
+
+	mu     sync.RWMutex
+	routes []portRoute
+
+	// a single heartbeat probes the range instead of one per port
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewPortRangeRoute(config utils.RouteConfig, server *tsnet.Server, deps RouteDeps) *PortRangeRoute {
+	route := &PortRangeRoute{
+		config: config,
+		server: server,
+		deps:   deps,
+		data:   utils.NewTimeSeries(time.Second, 1000),
+	}
+	route.routes = route.build()
+	return route
+}
+
+func (route *PortRangeRoute) build() []portRoute {
+	routes := []portRoute{}
+	for offset, port := range route.config.Ports() {
+		config := route.config
+		config.Port = port
+		config.PortEnd = 0
+		config.Machine.Port = route.config.Machine.Port + uint16(offset)
+
+		var child portRoute
+		if config.Type == utils.UDP {
+			child = NewUDPRoute(config, route.server, route.deps)
+		} else {
+			child = NewTCPRoute(config, route.server, route.deps)
+		}
+		child.setData(route.data)
+		child.disableHeartbeat()
+		routes = append(routes, child)
+	}
+	return routes
+}
+
+func (route *PortRangeRoute) Config() utils.RouteConfig {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	return route.config
+}
+
+func (route *PortRangeRoute) Stats() utils.TimeSeriesData {
+	return route.data.Data
+}
+
+// Status is running when every port is running, starting or stopping while ports are
+// changing state and stopped otherwise
+func (route *PortRangeRoute) Status() RouterStatus {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	counts := map[RouterStatus]int{}
+	for _, child := range route.routes {
+		counts[child.Status()]++
+	}
+	switch {
+	case counts[RUNNING] == len(route.routes):
+		return RUNNING
+	case counts[STARTING] > 0:
+		return STARTING
+	case counts[STOPPING] > 0:
+		return STOPPING
+	case counts[DRAINING] > 0:
+		return DRAINING
+	}
+	return STOPPED
+}
+
+func (route *PortRangeRoute) Ping() time.Duration {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	if len(route.routes) == 0 {
+		return -1
+	}
+	return route.routes[0].Ping()
+}
+
+func (route *PortRangeRoute) Start() error {
+	route.mu.Lock()
+	defer route.mu.Unlock()
+	if route.quit == nil {
+		route.quit = make(chan struct{})
+		route.wg.Add(1)
+		go route.runHeartbeat(route.quit)
+	}
+	var errs []error
+	for _, child := range route.routes {
+		if child.Status() == RUNNING {
+			continue
+		}
+		if err := child.Start(); err != nil {
+			errs = append(errs, fmt.Errorf("port %d: %w", child.Config().Port, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (route *PortRangeRoute) Stop() error {
+	route.mu.Lock()
+	if route.quit != nil {
+		close(route.quit)
+		route.quit = nil
+	}
+	for _, child := range route.routes {
+		if child.Status() == RUNNING {
+			child.Stop()
+		}
+	}
+	route.mu.Unlock()
+	route.wg.Wait()
+	return nil
+}
+
+func (route *PortRangeRoute) runHeartbeat(quit chan struct{}) {
+	defer route.wg.Done()
+	ticker := time.NewTicker(tcpHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			route.heartbeat()
+		}
+	}
+}
+
+// heartbeat follows the backend node of every udp port and probes the first port,
+// every port reaches the same backend machine
+func (route *PortRangeRoute) heartbeat() {
+	route.mu.RLock()
+	routes := route.routes
+	route.mu.RUnlock()
+	for _, child := range routes {
+		if udp, ok := child.(*UDPRoute); ok {
+			udp.resolveRemote()
+		}
+	}
+	if len(routes) > 0 && routes[0].Status() == RUNNING {
+		routes[0].measureLatency()
+	}
+}
+
+// Update applies config to each port in place when the range is unchanged so
+// active connections are kept, otherwise the ports are rebuilt.
+func (route *PortRangeRoute) Update(config utils.RouteConfig) error {
+	route.mu.Lock()
+	if route.config.Type == config.Type && slices.Equal(route.config.Ports(), config.Ports()) {
+		route.config = config
+		var errs []error
+		for offset, child := range route.routes {
+			childConfig := config
+			childConfig.Port = child.Config().Port
+			childConfig.PortEnd = 0
+			childConfig.Machine.Port = config.Machine.Port + uint16(offset)
+			if err := child.Update(childConfig); err != nil {
+				errs = append(errs, fmt.Errorf("port %d: %w", childConfig.Port, err))
+			}
+		}
+		route.mu.Unlock()
+		return errors.Join(errs...)
+	}
+	route.mu.Unlock()
+
+	running := route.Status() != STOPPED
+	route.Stop()
+
+	route.mu.Lock()
+	route.config = config
+	route.routes = route.build()
+	route.mu.Unlock()
+
+	if running {
+		return route.Start()
+	}
+	return nil
+}
+
+// ActiveConnections returns the number of live connections across every port
+func (route *PortRangeRoute) ActiveConnections() int64 {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	var count int64
+	for _, child := range route.routes {
+		if conns, ok := child.(ConnectionRoute); ok {
+			count += conns.ActiveConnections()
+		}
+	}
+	return count
+}
+
+// Connections lists the live connections across every port
+func (route *PortRangeRoute) Connections() []Connection {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	connections := []Connection{}
+	for _, child := range route.routes {
+		if conns, ok := child.(ConnectionRoute); ok {
+			connections = append(connections, conns.Connections()...)
+		}
+	}
+	return connections
+}
+
+func (route *PortRangeRoute) CloseConnection(id string) bool {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	for _, child := range route.routes {
+		if conns, ok := child.(ConnectionRoute); ok && conns.CloseConnection(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package router
+
+import (
+	"testing"
+	"warptail/pkg/utils"
+)
+
+// Ports of a stopped range stay stopped when its config changes, and leave the
+// heartbeat to the range
+func TestPortRangeRouteUpdateStopped(t *testing.T) {
+	config := memoryRoute(utils.UDP, 9000)
+	config.Port, config.PortEnd = 40000, 40003
+	route := NewPortRangeRoute(config, nil, RouteDeps{dialer: newMemoryNetwork()})
+
+	config.Machine.Port = 9100
+	if err := route.Update(config); err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range route.routes {
+		udp := child.(*UDPRoute)
+		if status := udp.Status(); status != STOPPED {
+			t.Fatalf("port %d is %s after updating a stopped range", udp.Config().Port, status)
+		}
+		if !udp.noHeartbeat {
+			t.Fatalf("port %d runs its own heartbeat", udp.Config().Port)
+		}
+	}
+	if port := route.routes[3].Config().Machine.Port; port != 9103 {
+		t.Fatalf("last port forwards to %d, want 9103", port)
+	}
+}
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"warptail/pkg/utils"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// proxyPolicy returns the policy for upstreams allowed to send PROXY protocol headers,
+// nil without trusted proxies as the config requires them to accept headers.
+func proxyPolicy(settings *utils.ProxyProtocolSettings) (proxyproto.PolicyFunc, error) {
+	if settings == nil || len(settings.TrustedProxies) == 0 {
+		return nil, nil
+	}
+	return proxyproto.StrictWhiteListPolicy(settings.TrustedProxies)
+}
+
+// acceptProxyProtocol wraps a listener so PROXY protocol headers sent by a load
+// balancer in front of warptail replace the connection source address.
+// When trusted proxies are configured, headers from anyone else are rejected.
+func acceptProxyProtocol(listener net.Listener, settings *utils.ProxyProtocolSettings) (net.Listener, error) {
+	if settings == nil || !settings.Accept {
+		return listener, nil
+	}
+	policy, err := proxyPolicy(settings)
+	if err != nil {
+		return nil, err
+	}
+	ppListener := &proxyproto.Listener{Listener: listener}
+	if policy != nil {
+		ppListener.ConnPolicy = func(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			return policy(opts.Upstream)
+		}
+	}
+	return ppListener, nil
+}
+
+// writeProxyHeader sends a PROXY protocol header describing the client connection to the backend
+func writeProxyHeader(dst io.Writer, version int, source, destination net.Addr) error {
+	header := proxyproto.HeaderProxyFromAddrs(byte(version), source, destination)
+	_, err := header.WriteTo(dst)
+	return err
+}
+
+// proxyDatagram prefixes a UDP payload with a PROXY protocol v2 header
+func proxyDatagram(payload []byte, source, destination net.Addr) ([]byte, error) {
+	header, err := proxyproto.HeaderProxyFromAddrs(2, source, destination).Format()
+	if err != nil {
+		return nil, err
+	}
+	return append(header, payload...), nil
+}
+
+// trustedProxy reports whether a datagram source may send a PROXY protocol header
+func trustedProxy(addr net.Addr, policy proxyproto.PolicyFunc) bool {
+	if policy == nil {
+		return true
+	}
+	result, err := policy(addr)
+	return err == nil && result == proxyproto.USE
+}
+
+// hasProxyHeader reports whether a datagram starts with a PROXY protocol signature
+func hasProxyHeader(packet []byte) bool {
+	return bytes.HasPrefix(packet, proxyproto.SIGV2) || bytes.HasPrefix(packet, []byte("PROXY "))
+}
+
+// readProxyDatagram strips a PROXY protocol v2 header from a UDP payload returning
+// the original client address, payloads without a header are returned unchanged.
+func readProxyDatagram(packet []byte) (net.Addr, []byte, error) {
+	reader := bytes.NewReader(packet)
+	buffered := bufio.NewReaderSize(reader, len(packet))
+	header, err := proxyproto.Read(buffered)
+	if errors.Is(err, proxyproto.ErrNoProxyProtocol) {
+		return nil, packet, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	consumed := len(packet) - reader.Len() - buffered.Buffered()
+	if header.Command.IsLocal() {
+		return nil, packet[consumed:], nil
+	}
+	return header.SourceAddr, packet[consumed:], nil
+}
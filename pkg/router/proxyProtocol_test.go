@@ -0,0 +1,53 @@
+package router
+
+import (
+	"net"
+	"testing"
+	"warptail/pkg/utils"
+)
+
+func TestUDPProxyProtocolTrust(t *testing.T) {
+	config := utils.RouteConfig{
+		Type:          utils.UDP,
+		ProxyProtocol: &utils.ProxyProtocolSettings{Accept: true, TrustedProxies: []string{"10.0.0.1"}},
+	}
+	route := NewUDPRoute(config, nil, RouteDeps{})
+	policy, err := proxyPolicy(config.ProxyProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.proxyPolicy = policy
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 4000}
+	headed, err := proxyDatagram([]byte("payload"), client, &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	untrusted := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}
+
+	tests := []struct {
+		name    string
+		from    net.Addr
+		packet  []byte
+		ok      bool
+		source  string
+		payload string
+	}{
+		{name: "trusted header", from: trusted, packet: headed, ok: true, source: client.String(), payload: "payload"},
+		{name: "untrusted header", from: untrusted, packet: headed, ok: false},
+		{name: "untrusted plain", from: untrusted, packet: []byte("payload"), ok: true, source: untrusted.String(), payload: "payload"},
+	}
+	for _, test := range tests {
+		payload, source, ok := route.proxyProtocol(test.packet, test.from)
+		if ok != test.ok {
+			t.Fatalf("%s: forwarded %v, want %v", test.name, ok, test.ok)
+		}
+		if !ok {
+			continue
+		}
+		if source.String() != test.source || string(payload) != test.payload {
+			t.Fatalf("%s: got %q from %s, want %q from %s", test.name, payload, source, test.payload, test.source)
+		}
+	}
+}
@@ -2,22 +2,66 @@ package router
 
 import (
 	"fmt"
+	"net/http"
 	"warptail/pkg/utils"
 
+	"golang.org/x/crypto/acme/autocert"
 	"tailscale.com/tsnet"
 )
 
-func NewRoute(config utils.RouteConfig, ts *tsnet.Server) (Route, error) {
+// RouteDeps are the router wide dependencies routes are built with, they are set
+// on the router before it starts any route and copied into every service
+type RouteDeps struct {
+	// CertificateManager issues certificates for TCP routes that terminate TLS
+	// without a user supplied certificate, it is set when ACME is enabled.
+	CertificateManager *autocert.Manager
+	// ExposeHandler serves requests arriving on the tailnet listeners of exposed
+	// routes, it is the same handler as the public listeners so authentication,
+	// bot protection and access logging apply. Routes serve directly when unset.
+	ExposeHandler http.Handler
+	// Passthrough is set when the HTTPS listener hands connections to the
+	// SNIListener, passthrough routes refuse to start without it.
+	Passthrough bool
+
+	// dialer replaces the dialer of the route config, tests use it to reach
+	// backends on an in process network
+	dialer Dialer
+}
+
+func NewRoute(config utils.RouteConfig, ts *tsnet.Server, deps RouteDeps) (Route, error) {
+	if (config.Type == utils.TCP || config.Type == utils.UDP) && config.IsPortRange() {
+		return NewPortRangeRoute(config, ts, deps), nil
+	}
 	switch config.Type {
 	case utils.UDP:
-		return NewUDPRoute(config, ts), nil
-	case utils.TCP:
-		return NewTCPRoute(config, ts), nil
+		return NewUDPRoute(config, ts, deps), nil
+	case utils.TCP, utils.TLS_PASSTHROUGH:
+		return NewTCPRoute(config, ts, deps), nil
 	case utils.HTTP:
-		return NewHTTPRoute(config, ts), nil
+		return NewHTTPRoute(config, ts, deps), nil
 	case utils.HTTPS:
-		return NewHTTPRoute(config, ts), nil
+		return NewHTTPRoute(config, ts, deps), nil
+	case utils.FILES:
+		return NewFileRoute(config), nil
 	default:
 		return nil, fmt.Errorf("no handler for type %s", config.Type)
 	}
 }
+
+// HandlerRoute is a route served by the shared HTTP listener and matched on domain
+type HandlerRoute interface {
+	Route
+	Handle(w http.ResponseWriter, r *http.Request)
+}
+
+// Upstream describes where a route sends its traffic, used for logs and metrics
+func Upstream(config utils.RouteConfig) string {
+	if config.Type == utils.FILES && config.FileSettings != nil {
+		return config.FileSettings.Root + config.FileSettings.Archive
+	}
+	host := config.Machine.Address
+	if len(config.Machine.NodeName) > 0 && !config.IsReverse() {
+		host = config.Machine.NodeName
+	}
+	return fmt.Sprintf("%s:%d", host, config.Machine.Port)
+}
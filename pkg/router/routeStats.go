@@ -11,6 +11,7 @@ const (
 	STARTING = RouterStatus("Starting")
 	RUNNING  = RouterStatus("Running")
 	STOPPING = RouterStatus("Stopping")
+	DRAINING = RouterStatus("Draining")
 	STOPPED  = RouterStatus("Stopped")
 )
 
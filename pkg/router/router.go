@@ -7,17 +7,21 @@ import (
 	"warptail/pkg/utils"
 
 	"github.com/gosimple/slug"
-	"tailscale.com/tsnet"
 )
 
 var ServiceNotFoundError = utils.NotFoundError("service not found")
 
 type Router struct {
 	Services    map[string]*Service
-	ts          *tsnet.Server
 	Controllers []Controller
 	mu          sync.RWMutex
 	ready       bool
+
+	tailnets map[string]*Tailnet
+	tnMu     sync.RWMutex
+
+	// Deps must be set before Init, routes copy them when they are created
+	Deps RouteDeps
 }
 
 type RouteInfo struct {
@@ -31,6 +35,7 @@ func NewRouter() *Router {
 		Services:    make(map[string]*Service),
 		Controllers: []Controller{},
 		ready:       false,
+		tailnets:    make(map[string]*Tailnet),
 	}
 	return router
 }
@@ -50,9 +55,8 @@ func (r *Router) SetReady(ready bool) {
 }
 
 func (r *Router) Init(config utils.Config) error {
-	err := r.UpdateTailscale(config.Tailscale)
-	if err != nil {
-		return err
+	if err := r.UpdateTailnets(config.Tailscale, config.Tailnets); err != nil {
+		utils.Logger.Info("Tailscale not connected yet, routes start once it is running", "error", err.Error())
 	}
 	for _, service := range config.Services {
 		if _, err := r.Create(service); err != nil {
@@ -65,8 +69,8 @@ func (r *Router) Init(config utils.Config) error {
 }
 
 func (r *Router) Reload(config utils.Config) error {
-	if err := r.UpdateTailscale(config.Tailscale); err != nil {
-		return err
+	if err := r.UpdateTailnets(config.Tailscale, config.Tailnets); err != nil {
+		utils.Logger.Info("Tailscale not connected yet, routes resume once it is running", "error", err.Error())
 	}
 	for _, svc := range config.Services {
 		if r.DoesExists(svc.Name) {
@@ -89,6 +93,7 @@ func (r *Router) Reload(config utils.Config) error {
 		if svc, ok := r.Services[key]; ok {
 			for _, route := range svc.Routes {
 				route.Stop()
+				diagnostics.Delete(route)
 			}
 			delete(r.Services, key)
 		}
@@ -112,11 +117,13 @@ func (r *Router) Create(svc utils.ServiceConfig) (*Service, *utils.RouterError)
 	if _, ok := r.Services[id]; ok {
 		return nil, utils.CustomError(http.StatusConflict, "service already exists unable to load config")
 	}
-	service := NewService(svc, r.ts)
+	tailnet := r.tailnet(svc.Tailnet)
+	service := NewService(svc, tailnet.Server(), r.Deps)
 	r.Services[service.Id] = service
 
+	// Paused routes are started by the supervisor once the tailnet is running
 	if service.Enabled {
-		service.Start()
+		service.startRoutes(tailnet.paused())
 	}
 
 	return service, nil
@@ -141,19 +148,40 @@ func (r *Router) Get(id string) (*Service, *utils.RouterError) {
 	return nil, ServiceNotFoundError
 }
 
-func (r *Router) GetHttpRoute(domain string) (*HTTPRoute, *utils.RouterError) {
+func (r *Router) GetHttpRoute(domain string) (HandlerRoute, *utils.RouterError) {
+	_, route, err := r.FindHttpRoute(domain)
+	return route, err
+}
+
+// FindHttpRoute returns the route serving domain along with the service it belongs to
+func (r *Router) FindHttpRoute(domain string) (*Service, HandlerRoute, *utils.RouterError) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, svc := range r.Services {
+		for _, route := range svc.Routes {
+			handler, ok := route.(HandlerRoute)
+			// Reverse routes are served on the tailnet node, never the public listener
+			if ok && !route.Config().IsReverse() && route.Config().IsPublic() && route.Config().Domain == domain {
+				return svc, handler, nil
+			}
+		}
+	}
+	return nil, nil, utils.NotFoundError("route not found")
+}
+
+// FindExposedRoute returns the https route published on the tailnet node for domain
+func (r *Router) FindExposedRoute(domain string) (*Service, HandlerRoute, *utils.RouterError) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for _, svc := range r.Services {
 		for _, route := range svc.Routes {
-			if route.Config().Type == utils.HTTP || route.Config().Type == utils.HTTPS {
-				if route.Config().Domain == domain {
-					return route.(*HTTPRoute), nil
-				}
+			handler, ok := route.(HandlerRoute)
+			if ok && route.Config().IsTailnetExposed() && route.Config().Domain == domain {
+				return svc, handler, nil
 			}
 		}
 	}
-	return nil, utils.NotFoundError("route not found")
+	return nil, nil, utils.NotFoundError("route not found")
 }
 
 func (r *Router) Update(id string, svc utils.ServiceConfig) (*Service, *utils.RouterError) {
@@ -163,7 +191,7 @@ func (r *Router) Update(id string, svc utils.ServiceConfig) (*Service, *utils.Ro
 	if !ok {
 		return nil, ServiceNotFoundError
 	}
-	existing.Update(svc, r.ts)
+	existing.Update(svc, r.server(svc.Tailnet))
 	if id != existing.Id {
 		r.Services[existing.Id] = existing
 		delete(r.Services, id)
@@ -171,6 +199,29 @@ func (r *Router) Update(id string, svc utils.ServiceConfig) (*Service, *utils.Ro
 	return existing, nil
 }
 
+// KeepDialerPasswords fills the empty dialer passwords of svc from the matching
+// routes of the service, the dashboard never receives them
+func (r *Router) KeepDialerPasswords(id string, svc *utils.ServiceConfig) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	existing, ok := r.Services[id]
+	if !ok {
+		return
+	}
+	for i, cfg := range svc.Routes {
+		if cfg.Dialer == nil || len(cfg.Dialer.Password) > 0 {
+			continue
+		}
+		route, err := containsRoute(existing.Routes, cfg)
+		if err != nil || route.Config().Dialer == nil {
+			continue
+		}
+		dialer := *cfg.Dialer
+		dialer.Password = route.Config().Dialer.Password
+		svc.Routes[i].Dialer = &dialer
+	}
+}
+
 func (r *Router) Remove(id string) *utils.RouterError {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -180,6 +231,7 @@ func (r *Router) Remove(id string) *utils.RouterError {
 	}
 	for _, route := range svc.Routes {
 		route.Stop()
+		diagnostics.Delete(route)
 	}
 	delete(r.Services, id)
 	return nil
@@ -207,8 +259,16 @@ func (r *Router) StopAll() {
 	}
 }
 
-func (r *Router) GetPeers() ([]TailscalePeers, *utils.RouterError) {
-	c, _ := r.ts.LocalClient()
+// GetPeers returns the peers of the named tailnet, the default one when empty
+func (r *Router) GetPeers(tailnet string) ([]TailscalePeers, *utils.RouterError) {
+	ts := r.server(tailnet)
+	if ts == nil {
+		return []TailscalePeers{}, utils.CustomError(http.StatusInternalServerError, "unable to get tailscale status")
+	}
+	c, err := ts.LocalClient()
+	if err != nil {
+		return []TailscalePeers{}, utils.CustomError(http.StatusInternalServerError, "unable to get tailscale status")
+	}
 	status, err := c.Status(context.Background())
 	if err != nil {
 		return []TailscalePeers{}, utils.CustomError(http.StatusInternalServerError, "unable to get tailscale status")
@@ -219,16 +279,13 @@ func (r *Router) GetPeers() ([]TailscalePeers, *utils.RouterError) {
 		if len(peer.TailscaleIPs) == 0 {
 			continue
 		}
-		ip := peer.TailscaleIPs[0].String()
-		key := peer.HostName + ":" + ip
+		info := peerInfo(peer)
+		key := peer.HostName + ":" + info.IP
 		if seen[key] {
 			continue
 		}
 		seen[key] = true
-		nodes = append(nodes, TailscalePeers{
-			HostName: peer.HostName,
-			IP:       ip,
-		})
+		nodes = append(nodes, info)
 	}
 	return nodes, nil
 }
@@ -0,0 +1,59 @@
+package router
+
+import (
+	"testing"
+	"warptail/pkg/utils"
+)
+
+// Routes off the tailnet serve while the tailnet is still connecting
+func TestCreateStartsRoutesOffPausedTailnet(t *testing.T) {
+	r := NewRouter()
+	r.tailnets[DefaultTailnet] = newTailnet(DefaultTailnet, r)
+	svc, err := r.Create(utils.ServiceConfig{
+		Name:    "site",
+		Enabled: true,
+		Routes: []utils.RouteConfig{
+			{Type: utils.FILES, Domain: "site.example.com", FileSettings: &utils.FileSettings{Root: t.TempDir()}},
+			{Type: utils.TCP, ListenAddress: "127.0.0.1", Machine: utils.Machine{Address: "backend", Port: 22}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Message)
+	}
+	defer svc.Stop()
+	if status := svc.Routes[0].Status(); status != RUNNING {
+		t.Fatalf("files route is %s, want %s", status, RUNNING)
+	}
+	if status := svc.Routes[1].Status(); status != STOPPED {
+		t.Fatalf("tailnet route is %s while the tailnet is paused, want %s", status, STOPPED)
+	}
+}
+
+// The dialer password is hidden from the dashboard and kept when it sends the route back
+func TestDialerPasswordRedacted(t *testing.T) {
+	r := NewRouter()
+	config := utils.ServiceConfig{
+		Name: "site",
+		Routes: []utils.RouteConfig{{
+			Type:    utils.HTTP,
+			Domain:  "site.example.com",
+			Machine: utils.Machine{Address: "backend", Port: 80},
+			Dialer:  &utils.DialerSettings{Type: utils.SOCKS5Dialer, Proxy: "proxy:1080", Username: "user", Password: "secret"},
+		}},
+	}
+	svc := NewService(config, nil, RouteDeps{})
+	r.Services[svc.Id] = svc
+
+	sent := svc.Status(false).Routes[0].RouteConfig
+	if sent.Dialer.Password != "" {
+		t.Fatal("route status contains the dialer password")
+	}
+	update := utils.ServiceConfig{Name: "site", Routes: []utils.RouteConfig{sent}}
+	r.KeepDialerPasswords(svc.Id, &update)
+	if password := update.Routes[0].Dialer.Password; password != "secret" {
+		t.Fatalf("update has dialer password %q, want the stored one", password)
+	}
+	if svc.Routes[0].Config().Dialer.Password != "secret" {
+		t.Fatal("redacting the status changed the route config")
+	}
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"fmt"
+	"slices"
 	"time"
 	"warptail/pkg/utils"
 
@@ -22,13 +23,16 @@ type Service struct {
 	Id      string
 	Name    string
 	Enabled bool
+	Tailnet string
 	Routes  []Route
+
+	deps RouteDeps
 }
 
-func NewService(config utils.ServiceConfig, server *tsnet.Server) *Service {
+func NewService(config utils.ServiceConfig, server *tsnet.Server, deps RouteDeps) *Service {
 	routes := []Route{}
 	for _, cfg := range config.Routes {
-		if route, err := NewRoute(cfg, server); err == nil {
+		if route, err := NewRoute(cfg, server, deps); err == nil {
 			routes = append(routes, route)
 		}
 	}
@@ -36,7 +40,9 @@ func NewService(config utils.ServiceConfig, server *tsnet.Server) *Service {
 		Id:      slug.Make(config.Name),
 		Name:    config.Name,
 		Enabled: config.Enabled,
+		Tailnet: config.Tailnet,
 		Routes:  routes,
+		deps:    deps,
 	}
 }
 
@@ -50,6 +56,15 @@ func (svc *Service) Update(config utils.ServiceConfig, server *tsnet.Server) *ut
 	if !svc.Enabled {
 		svc.Stop()
 	}
+	if svc.Tailnet != config.Tailnet {
+		for _, route := range svc.Routes {
+			if route.Config().NeedsTailnet() {
+				route.Stop()
+			}
+		}
+		svc.rebind(server)
+		svc.Tailnet = config.Tailnet
+	}
 
 	existingRoutes := []Route{}
 	newRoutes := []utils.RouteConfig{}
@@ -69,6 +84,7 @@ func (svc *Service) Update(config utils.ServiceConfig, server *tsnet.Server) *ut
 func (svc *Service) pruneRoutes(existingRoutes []Route) {
 	for _, route := range svc.Routes {
 		if _, err := containsRoute(existingRoutes, route.Config()); err != nil {
+			diagnostics.Delete(route)
 			if svc.Enabled {
 				route.Stop()
 			}
@@ -78,7 +94,7 @@ func (svc *Service) pruneRoutes(existingRoutes []Route) {
 
 func (svc *Service) updateNewRoutes(existingRoutes []Route, newRoutes []utils.RouteConfig, server *tsnet.Server) {
 	for _, cfg := range newRoutes {
-		if route, err := NewRoute(cfg, server); err == nil {
+		if route, err := NewRoute(cfg, server, svc.deps); err == nil {
 			if svc.Enabled {
 				route.Start()
 			}
@@ -95,19 +111,45 @@ func (svc *Service) updateNewRoutes(existingRoutes []Route, newRoutes []utils.Ro
 	}
 }
 
+// rebind recreates the routes needing the tailnet on a new tsnet server, those
+// must already be stopped. Other routes keep running untouched.
+func (svc *Service) rebind(server *tsnet.Server) {
+	routes := []Route{}
+	for _, route := range svc.Routes {
+		if !route.Config().NeedsTailnet() {
+			routes = append(routes, route)
+			continue
+		}
+		diagnostics.Delete(route)
+		if next, err := NewRoute(route.Config(), server, svc.deps); err == nil {
+			routes = append(routes, next)
+		}
+	}
+	svc.Routes = routes
+}
+
 type ServiceStatus struct {
-	Id      string        `json:"id"`
-	Name    string        `json:"name"`
-	Enabled bool          `json:"enabled"`
-	Routes  []RouteStatus `json:"routes"`
-	Latency int64         `json:"latency,omitempty"`
+	Id       string        `json:"id"`
+	Name     string        `json:"name"`
+	Enabled  bool          `json:"enabled"`
+	Tailnet  string        `json:"tailnet,omitempty"`
+	Routes   []RouteStatus `json:"routes"`
+	Latency  int64         `json:"latency,omitempty"`
+	Warnings []string      `json:"warnings,omitempty"`
 }
 
 type RouteStatus struct {
 	utils.RouteConfig
-	Status  RouterStatus         `json:"status,omitempty"`
-	Latency int64                `json:"latency,omitempty"`
-	Stats   utils.TimeSeriesData `json:"stats,omitempty"`
+	Status      RouterStatus         `json:"status,omitempty"`
+	Latency     int64                `json:"latency,omitempty"`
+	WebSockets  int64                `json:"websockets,omitempty"`
+	Connections int64                `json:"connections,omitempty"`
+	Sessions    int64                `json:"sessions,omitempty"`
+	TailnetURL  string               `json:"tailnet_url,omitempty"`
+	SubnetRoute *SubnetRoute         `json:"subnet_route,omitempty"`
+	Diagnostic  *RouteDiagnostic     `json:"diagnostic,omitempty"`
+	TailnetPath *TailnetPath         `json:"tailnet_path,omitempty"`
+	Stats       utils.TimeSeriesData `json:"stats,omitempty"`
 }
 
 func (svc *Service) Status(full bool) ServiceStatus {
@@ -115,16 +157,42 @@ func (svc *Service) Status(full bool) ServiceStatus {
 		Id:      svc.Id,
 		Name:    svc.Name,
 		Enabled: svc.Enabled,
+		Tailnet: svc.Tailnet,
 		Routes:  []RouteStatus{},
 	}
 	totalLatency := time.Duration(0)
 
 	for _, routes := range svc.Routes {
 		rStatus := RouteStatus{
-			RouteConfig: routes.Config(),
+			RouteConfig: redactSecrets(routes.Config()),
 			Status:      routes.Status(),
 			Latency:     routes.Ping().Nanoseconds(),
 		}
+		if ws, ok := routes.(interface{ ActiveWebSockets() int64 }); ok {
+			rStatus.WebSockets = ws.ActiveWebSockets()
+		}
+		if exposed, ok := routes.(interface{ TailnetURL() string }); ok {
+			rStatus.TailnetURL = exposed.TailnetURL()
+		}
+		if subnet, ok := routes.(interface{ SubnetRoute() *SubnetRoute }); ok {
+			rStatus.SubnetRoute = subnet.SubnetRoute()
+			if rStatus.SubnetRoute != nil {
+				if warning := rStatus.SubnetRoute.Warning(); len(warning) > 0 && !slices.Contains(status.Warnings, warning) {
+					status.Warnings = append(status.Warnings, warning)
+				}
+			}
+		}
+		rStatus.Diagnostic = lastDiagnostic(routes)
+		if path, ok := routes.(interface{ TailnetPath() *TailnetPath }); ok {
+			rStatus.TailnetPath = path.TailnetPath()
+		}
+		if conns, ok := routes.(ConnectionRoute); ok {
+			if rStatus.Type == utils.UDP {
+				rStatus.Sessions = conns.ActiveConnections()
+			} else {
+				rStatus.Connections = conns.ActiveConnections()
+			}
+		}
 		if full {
 			rStatus.Stats = routes.Stats()
 		}
@@ -137,6 +205,16 @@ func (svc *Service) Status(full bool) ServiceStatus {
 	return status
 }
 
+// redactSecrets drops the dialer password from the config returned to the dashboard
+func redactSecrets(config utils.RouteConfig) utils.RouteConfig {
+	if config.Dialer != nil && len(config.Dialer.Password) > 0 {
+		dialer := *config.Dialer
+		dialer.Password = ""
+		config.Dialer = &dialer
+	}
+	return config
+}
+
 func (svc *Service) Stop() {
 	for _, route := range svc.Routes {
 		route.Stop()
@@ -144,6 +222,17 @@ func (svc *Service) Stop() {
 	svc.Enabled = false
 }
 
+// startRoutes starts the routes that are not running, routes needing the tailnet
+// are left to the supervisor while it is paused
+func (svc *Service) startRoutes(paused bool) {
+	for _, route := range svc.Routes {
+		if route.Status() == RUNNING || (paused && route.Config().NeedsTailnet()) {
+			continue
+		}
+		route.Start()
+	}
+}
+
 func (svc *Service) Start() {
 	for _, route := range svc.Routes {
 		route.Start()
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"warptail/pkg/utils"
+)
+
+const (
+	sniPeekTimeout         = 5 * time.Second
+	tlsRecordTypeHandshake = 0x16
+)
+
+var errClientHelloRead = errors.New("client hello read")
+
+// SNIListener sits in front of the HTTPS listener and peeks at the TLS ClientHello
+// of every connection. Connections whose SNI matches a tls-passthrough route are
+// forwarded as raw TCP, everything else is returned from Accept untouched.
+type SNIListener struct {
+	net.Listener
+	router *Router
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewSNIListener(listener net.Listener, router *Router) *SNIListener {
+	sl := &SNIListener{
+		Listener: listener,
+		router:   router,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
+	go sl.acceptLoop()
+	return sl
+}
+
+func (sl *SNIListener) acceptLoop() {
+	for {
+		conn, err := sl.Listener.Accept()
+		if err != nil {
+			select {
+			case sl.errs <- err:
+			case <-sl.closed:
+			}
+			return
+		}
+		go sl.dispatch(conn)
+	}
+}
+
+// dispatch is run per connection so a slow ClientHello never blocks other clients
+func (sl *SNIListener) dispatch(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(sniPeekTimeout))
+	serverName, peeked := peekServerName(conn)
+	conn.SetReadDeadline(time.Time{})
+
+	replay := &peekedConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(peeked), conn)}
+	if serverName != "" {
+		if route, ok := sl.router.GetPassthroughRoute(serverName); ok {
+			route.HandleConn(replay)
+			return
+		}
+	}
+
+	select {
+	case sl.conns <- replay:
+	case <-sl.closed:
+		conn.Close()
+	}
+}
+
+func (sl *SNIListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-sl.conns:
+		return conn, nil
+	case err := <-sl.errs:
+		return nil, err
+	case <-sl.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (sl *SNIListener) Close() error {
+	sl.closeOnce.Do(func() {
+		close(sl.closed)
+	})
+	return sl.Listener.Close()
+}
+
+// peekedConn replays bytes consumed while reading the ClientHello
+type peekedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (pc *peekedConn) Read(p []byte) (int, error) {
+	return pc.reader.Read(p)
+}
+
+// recordingConn feeds a handshake that is never completed, capturing what was read
+type recordingConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (rc *recordingConn) Read(p []byte) (int, error) {
+	return rc.reader.Read(p)
+}
+
+func (rc *recordingConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// peekServerName reads the ClientHello from conn returning its SNI and the bytes
+// consumed. Non TLS connections are detected on the first byte and return no name.
+func peekServerName(conn net.Conn) (string, []byte) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		return "", nil
+	}
+	if first[0] != tlsRecordTypeHandshake {
+		return "", first
+	}
+
+	var peeked bytes.Buffer
+	peeked.Write(first)
+	reader := io.MultiReader(bytes.NewReader(first), io.TeeReader(conn, &peeked))
+
+	var serverName string
+	tls.Server(&recordingConn{Conn: conn, reader: reader}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+
+	return strings.ToLower(serverName), peeked.Bytes()
+}
+
+// GetPassthroughRoute returns the running tls-passthrough route matching serverName
+func (r *Router) GetPassthroughRoute(serverName string) (*TCPRoute, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, svc := range r.Services {
+		for _, route := range svc.Routes {
+			config := route.Config()
+			if config.Type != utils.TLS_PASSTHROUGH || !strings.EqualFold(config.Domain, serverName) {
+				continue
+			}
+			if tcp, ok := route.(*TCPRoute); ok && tcp.Status() == RUNNING {
+				return tcp, true
+			}
+		}
+	}
+	return nil, false
+}
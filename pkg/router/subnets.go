@@ -0,0 +1,146 @@
+package router
+
+import (
+	"net/netip"
+	"warptail/pkg/utils"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tsnet"
+)
+
+// SubnetRoute describes the peer carrying traffic to a backend outside the
+// tailnet address range, either a subnet router or the selected exit node
+type SubnetRoute struct {
+	Address  string `json:"address"`
+	Prefix   string `json:"prefix,omitempty"`
+	Peer     string `json:"peer,omitempty"`
+	PeerIP   string `json:"peer_ip,omitempty"`
+	Online   bool   `json:"online"`
+	Primary  bool   `json:"primary,omitempty"`
+	ExitNode bool   `json:"exit_node,omitempty"`
+}
+
+// advertisedRoute is a prefix a peer routes for
+type advertisedRoute struct {
+	prefix   netip.Prefix
+	peer     string
+	peerIP   string
+	online   bool
+	primary  bool
+	exitNode bool
+}
+
+// peerRoutes returns the subnet routes a peer is allowed to carry, exit node
+// routes are only kept for the exit node in use
+func peerRoutes(peer *ipnstate.PeerStatus) []advertisedRoute {
+	if peer.AllowedIPs == nil {
+		return nil
+	}
+	routes := []advertisedRoute{}
+	info := peerInfo(peer)
+	for _, prefix := range peer.AllowedIPs.All() {
+		if prefix.IsSingleIP() && tsaddr.IsTailscaleIP(prefix.Addr()) {
+			continue
+		}
+		if tsaddr.IsExitRoute(prefix) && !peer.ExitNode {
+			continue
+		}
+		route := advertisedRoute{
+			prefix:   prefix,
+			peer:     info.HostName,
+			peerIP:   info.IP,
+			online:   peer.Online,
+			exitNode: tsaddr.IsExitRoute(prefix),
+		}
+		if peer.PrimaryRoutes != nil {
+			for _, primary := range peer.PrimaryRoutes.All() {
+				if primary == prefix {
+					route.primary = true
+				}
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// subnetRoute returns the advertised route carrying addr, the most specific
+// prefix wins and among equal prefixes the primary, then an online peer
+func (resolver *nodeResolver) subnetRoute(addr netip.Addr) (advertisedRoute, bool) {
+	resolver.ensureFresh(nodeCacheTTL)
+	resolver.mu.RLock()
+	defer resolver.mu.RUnlock()
+	var best advertisedRoute
+	found := false
+	for _, route := range resolver.subnets {
+		if !route.prefix.Contains(addr) {
+			continue
+		}
+		switch {
+		case !found, route.prefix.Bits() > best.prefix.Bits():
+		case route.prefix.Bits() < best.prefix.Bits():
+			continue
+		case route.primary && !best.primary, route.online && !best.online && route.primary == best.primary:
+		default:
+			continue
+		}
+		best = route
+		found = true
+	}
+	return best, found
+}
+
+// subnetRouteFor returns how a route reaches a backend given by IP outside the
+// tailnet range, nil when the backend is a tailnet node or a hostname or the
+// route does not dial through the tailnet. A result without a peer means no peer
+// advertises the address.
+func subnetRouteFor(config utils.RouteConfig, ts *tsnet.Server) *SubnetRoute {
+	if ts == nil || !config.DialsTailnet() || len(config.Machine.NodeName) > 0 {
+		return nil
+	}
+	addr, err := netip.ParseAddr(config.Machine.Address)
+	if err != nil || tsaddr.IsTailscaleIP(addr) || addr.IsLoopback() {
+		return nil
+	}
+	via := &SubnetRoute{Address: addr.String()}
+	if route, ok := resolverFor(ts).subnetRoute(addr.Unmap()); ok {
+		via.Prefix = route.prefix.String()
+		via.Peer = route.peer
+		via.PeerIP = route.peerIP
+		via.Online = route.online
+		via.Primary = route.primary
+		via.ExitNode = route.exitNode
+	}
+	return via
+}
+
+// Warning explains why the backend cannot be reached, empty while the peer
+// carrying the route is online
+func (via *SubnetRoute) Warning() string {
+	switch {
+	case via.Online:
+		return ""
+	case len(via.Peer) == 0:
+		return "no tailnet peer advertises a route to " + via.Address
+	case via.ExitNode:
+		return "exit node " + via.Peer + " routing " + via.Address + " is offline"
+	}
+	return "subnet router " + via.Peer + " advertising " + via.Prefix + " for " + via.Address + " is offline"
+}
+
+func (route *HTTPRoute) SubnetRoute() *SubnetRoute {
+	return subnetRouteFor(route.Config(), route.ts)
+}
+
+func (route *TCPRoute) SubnetRoute() *SubnetRoute {
+	return subnetRouteFor(route.Config(), route.client)
+}
+
+func (route *UDPRoute) SubnetRoute() *SubnetRoute {
+	return subnetRouteFor(route.Config(), route.client)
+}
+
+func (route *PortRangeRoute) SubnetRoute() *SubnetRoute {
+	return subnetRouteFor(route.Config(), route.server)
+}
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"time"
+	"warptail/pkg/utils"
+
+	"tailscale.com/ipn"
+)
+
+const (
+	tailnetMinBackoff = 2 * time.Second
+	tailnetMaxBackoff = 5 * time.Minute
+)
+
+// Supervisor states, the remaining states are the tailscale backend states
+// such as NeedsLogin, Stopped and Running
+const (
+	TailnetRunning  = "Running"
+	TailnetStarting = "Starting"
+	TailnetRetrying = "Retrying"
+)
+
+// TailnetState is the tailnet connection as seen by the supervisor, routes are
+// paused whenever the backend leaves the Running state
+type TailnetState struct {
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Attempts int       `json:"attempts,omitempty"`
+	Since    time.Time `json:"since"`
+	Paused   bool      `json:"paused"`
+}
+
+// Running reports whether the tailnet is up and routes are serving
+func (state TailnetState) Running() bool {
+	return state.State == TailnetRunning
+}
+
+// State returns the current connection state of the tailnet
+func (tailnet *Tailnet) State() TailnetState {
+	tailnet.mu.RLock()
+	defer tailnet.mu.RUnlock()
+	return tailnet.state
+}
+
+func (tailnet *Tailnet) setState(state string, err error, attempts int) {
+	tailnet.mu.Lock()
+	defer tailnet.mu.Unlock()
+	if tailnet.state.State != state {
+		tailnet.state.Since = time.Now()
+	}
+	tailnet.state.State = state
+	tailnet.state.Attempts = attempts
+	tailnet.state.Error = ""
+	if err != nil {
+		tailnet.state.Error = err.Error()
+	}
+}
+
+// startSupervisor replaces any running supervisor with one for the current node
+func (tailnet *Tailnet) startSupervisor() {
+	tailnet.stopSupervisor()
+	ctx, cancel := context.WithCancel(context.Background())
+	tailnet.mu.Lock()
+	tailnet.cancel = cancel
+	tailnet.mu.Unlock()
+	tailnet.setState(TailnetStarting, nil, 0)
+	go tailnet.supervise(ctx)
+}
+
+func (tailnet *Tailnet) stopSupervisor() {
+	tailnet.mu.Lock()
+	defer tailnet.mu.Unlock()
+	if tailnet.cancel != nil {
+		tailnet.cancel()
+		tailnet.cancel = nil
+	}
+}
+
+// supervise keeps the node connected, retrying with exponential backoff
+// whenever the node fails to start or the IPN bus is lost
+func (tailnet *Tailnet) supervise(ctx context.Context) {
+	backoff := tailnetMinBackoff
+	for attempt := 1; ; attempt++ {
+		connected, err := tailnet.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff, attempt = tailnetMinBackoff, 1
+		}
+		tailnet.setState(TailnetRetrying, err, attempt)
+		tailnet.pauseRoutes()
+		utils.Logger.Error(err, "tailscale connection failed, retrying", "tailnet", tailnet.Name, "attempt", attempt, "backoff", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, tailnetMaxBackoff)
+	}
+}
+
+// watch follows the backend state on the IPN bus until it fails,
+// reporting whether the node reached the Running state
+func (tailnet *Tailnet) watch(ctx context.Context) (bool, error) {
+	ts := tailnet.Server()
+	client, err := ts.LocalClient()
+	if err != nil {
+		// tsnet remembers a failed start, only a fresh node can try again
+		tailnet.pauseRoutes()
+		ts.Close()
+		tailnet.setServer(newTailscaleServer(tailnet.Name, tailnet.Config()), tailnet.Config())
+		forgetResolver(ts)
+		return false, err
+	}
+	watcher, err := client.WatchIPNBus(ctx, ipn.NotifyInitialState)
+	if err != nil {
+		return false, err
+	}
+	defer watcher.Close()
+
+	connected := false
+	for {
+		notify, err := watcher.Next()
+		if err != nil {
+			return connected, err
+		}
+		if notify.ErrMessage != nil {
+			return connected, errors.New(*notify.ErrMessage)
+		}
+		if notify.State == nil {
+			continue
+		}
+		state := *notify.State
+		tailnet.setState(state.String(), nil, 0)
+		switch state {
+		case ipn.Running:
+			connected = true
+			utils.Logger.Info("Tailscale connected successfully", "tailnet", tailnet.Name, "hostname", ts.Hostname)
+			tailnet.resumeRoutes()
+		case ipn.NeedsLogin, ipn.NeedsMachineAuth, ipn.Stopped:
+			utils.Logger.Info("Tailscale not running, pausing routes", "tailnet", tailnet.Name, "state", state.String())
+			tailnet.pauseRoutes()
+		}
+	}
+}
+
+// pauseRoutes stops the routes of enabled services on the tailnet that need it
+// without disabling them, routes off the tailnet keep serving
+func (tailnet *Tailnet) pauseRoutes() {
+	tailnet.mu.Lock()
+	paused := tailnet.state.Paused
+	tailnet.state.Paused = true
+	tailnet.mu.Unlock()
+	if paused {
+		return
+	}
+	tailnet.router.mu.RLock()
+	defer tailnet.router.mu.RUnlock()
+	for _, svc := range tailnet.router.servicesOn(tailnet) {
+		if !svc.Enabled {
+			continue
+		}
+		for _, route := range svc.Routes {
+			if route.Config().NeedsTailnet() {
+				route.Stop()
+			}
+		}
+	}
+}
+
+// resumeRoutes starts the routes of enabled services once the tailnet is back
+func (tailnet *Tailnet) resumeRoutes() {
+	tailnet.mu.Lock()
+	paused := tailnet.state.Paused
+	tailnet.state.Paused = false
+	tailnet.mu.Unlock()
+	if !paused {
+		return
+	}
+	tailnet.router.mu.RLock()
+	defer tailnet.router.mu.RUnlock()
+	for _, svc := range tailnet.router.servicesOn(tailnet) {
+		if svc.Enabled {
+			svc.startRoutes(false)
+		}
+	}
+}
+
+// paused reports whether routes are held back until the tailnet is running
+func (tailnet *Tailnet) paused() bool {
+	return tailnet.State().Paused
+}
@@ -0,0 +1,279 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+	"warptail/pkg/utils"
+
+	"tailscale.com/tsnet"
+)
+
+// DefaultTailnet is the tailnet configured under `tailscale`, services without a
+// tailnet, or naming one that is not configured, use it
+const DefaultTailnet = "default"
+
+// Tailnet is one tsnet node along with the supervisor keeping it connected
+type Tailnet struct {
+	Name   string
+	router *Router
+
+	mu     sync.RWMutex
+	ts     *tsnet.Server
+	config utils.TailscaleConfig
+	state  TailnetState
+	cancel context.CancelFunc
+}
+
+func newTailnet(name string, router *Router) *Tailnet {
+	return &Tailnet{
+		Name:   name,
+		router: router,
+		state:  TailnetState{State: TailnetStarting, Since: time.Now(), Paused: true},
+	}
+}
+
+// tailnetDir keeps the state of named tailnets apart, tsnet would otherwise put
+// every node in the same directory derived from the program name
+func tailnetDir(name string, config utils.TailscaleConfig) string {
+	if len(config.StateDir) > 0 || name == DefaultTailnet {
+		return config.StateDir
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tsnet-warptail-"+name)
+}
+
+// Server returns the tsnet node of the tailnet
+func (tailnet *Tailnet) Server() *tsnet.Server {
+	tailnet.mu.RLock()
+	defer tailnet.mu.RUnlock()
+	return tailnet.ts
+}
+
+// Config returns the tailscale config of the tailnet
+func (tailnet *Tailnet) Config() utils.TailscaleConfig {
+	tailnet.mu.RLock()
+	defer tailnet.mu.RUnlock()
+	return tailnet.config
+}
+
+// setServer swaps the node and moves the routes of its services onto it, the
+// routes must already be stopped
+func (tailnet *Tailnet) setServer(ts *tsnet.Server, config utils.TailscaleConfig) {
+	tailnet.mu.Lock()
+	tailnet.ts = ts
+	tailnet.config = config
+	tailnet.mu.Unlock()
+	tailnet.router.mu.Lock()
+	defer tailnet.router.mu.Unlock()
+	for _, svc := range tailnet.router.servicesOn(tailnet) {
+		svc.rebind(ts)
+	}
+}
+
+// update applies the config, restarting the node and its supervisor when it changed
+func (tailnet *Tailnet) update(config utils.TailscaleConfig) {
+	ts := tailnet.Server()
+	if ts != nil && !tailscaleChanged(tailnet.Config(), config) {
+		tailnet.mu.Lock()
+		tailnet.config = config
+		tailnet.mu.Unlock()
+		return
+	}
+	tailnet.stopSupervisor()
+	tailnet.pauseRoutes()
+	if ts != nil {
+		ts.Close()
+	}
+	tailnet.setServer(newTailscaleServer(tailnet.Name, config), config)
+	forgetResolver(ts)
+	tailnet.startSupervisor()
+}
+
+// close stops the supervisor and the node
+func (tailnet *Tailnet) close() {
+	tailnet.stopSupervisor()
+	tailnet.pauseRoutes()
+	if ts := tailnet.Server(); ts != nil {
+		ts.Close()
+	}
+}
+
+// tailnet returns the named tailnet, falling back to the default one
+func (r *Router) tailnet(name string) *Tailnet {
+	r.tnMu.RLock()
+	defer r.tnMu.RUnlock()
+	if tailnet, ok := r.tailnets[name]; ok {
+		return tailnet
+	}
+	return r.tailnets[DefaultTailnet]
+}
+
+// server returns the tsnet node of the named tailnet
+func (r *Router) server(name string) *tsnet.Server {
+	if tailnet := r.tailnet(name); tailnet != nil {
+		return tailnet.Server()
+	}
+	return nil
+}
+
+// servicesOn returns the services whose routes dial through tailnet, the caller
+// must hold r.mu for as long as it uses their routes
+func (r *Router) servicesOn(tailnet *Tailnet) []*Service {
+	svcs := []*Service{}
+	for _, svc := range r.Services {
+		if r.tailnet(svc.Tailnet) == tailnet {
+			svcs = append(svcs, svc)
+		}
+	}
+	return svcs
+}
+
+func (r *Router) tailnetList() []*Tailnet {
+	r.tnMu.RLock()
+	defer r.tnMu.RUnlock()
+	tailnets := []*Tailnet{}
+	for _, tailnet := range r.tailnets {
+		tailnets = append(tailnets, tailnet)
+	}
+	slices.SortFunc(tailnets, func(a, b *Tailnet) int {
+		switch {
+		case a.Name == b.Name:
+			return 0
+		case a.Name == DefaultTailnet:
+			return -1
+		case b.Name == DefaultTailnet:
+			return 1
+		case a.Name < b.Name:
+			return -1
+		}
+		return 1
+	})
+	return tailnets
+}
+
+func (r *Router) updateTailnet(name string, config utils.TailscaleConfig) {
+	r.tnMu.Lock()
+	tailnet, ok := r.tailnets[name]
+	if !ok {
+		tailnet = newTailnet(name, r)
+		r.tailnets[name] = tailnet
+	}
+	r.tnMu.Unlock()
+	tailnet.update(config)
+}
+
+// UpdateTailnets applies the default and named tailnets, closing those no longer
+// configured, and waits for them to come up. Services on a removed tailnet move
+// to the default one.
+func (r *Router) UpdateTailnets(config utils.TailscaleConfig, tailnets []utils.TailnetConfig) error {
+	r.updateTailnet(DefaultTailnet, config)
+	names := []string{DefaultTailnet}
+	for _, tailnet := range tailnets {
+		r.updateTailnet(tailnet.Name, tailnet.TailscaleConfig)
+		names = append(names, tailnet.Name)
+	}
+	for _, tailnet := range r.tailnetList() {
+		if !slices.Contains(names, tailnet.Name) {
+			r.removeTailnet(tailnet)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(60)*time.Second)
+	defer cancel()
+	return r.WaitForTailscale(ctx)
+}
+
+func (r *Router) removeTailnet(tailnet *Tailnet) {
+	ts := tailnet.Server()
+	tailnet.close()
+	defer forgetResolver(ts)
+	r.mu.Lock()
+	svcs := r.servicesOn(tailnet)
+	r.tnMu.Lock()
+	delete(r.tailnets, tailnet.Name)
+	r.tnMu.Unlock()
+
+	// services fall back to the default tailnet, the removed name would fail validation on the next load
+	fallback := r.tailnet(DefaultTailnet)
+	for _, svc := range svcs {
+		svc.rebind(fallback.Server())
+		svc.Tailnet = ""
+		if svc.Enabled {
+			svc.startRoutes(fallback.paused())
+		}
+	}
+	r.mu.Unlock()
+	utils.Logger.Info("Tailnet removed", "tailnet", tailnet.Name)
+	if len(svcs) > 0 {
+		r.Save()
+	}
+}
+
+// WaitForTailscale blocks until every tailnet is "Running" or context times out
+func (r *Router) WaitForTailscale(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		var waiting error
+		for _, tailnet := range r.tailnetList() {
+			if state := tailnet.State(); !state.Running() {
+				waiting = errors.Join(waiting, fmt.Errorf("tailnet %s is %s", tailnet.Name, state.State))
+			}
+		}
+		if waiting == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for tailscale to authenticate, %w: %w", waiting, ctx.Err())
+		case <-ticker.C:
+			utils.Logger.V(1).Info("Waiting for Tailscale authentication", "state", waiting.Error())
+		}
+	}
+}
+
+// TailnetState returns the connection state of the default tailnet
+func (r *Router) TailnetState() TailnetState {
+	if tailnet := r.tailnet(DefaultTailnet); tailnet != nil {
+		return tailnet.State()
+	}
+	return TailnetState{State: TailnetStarting, Paused: true}
+}
+
+// TailnetStates returns the connection state of every tailnet by name
+func (r *Router) TailnetStates() map[string]TailnetState {
+	states := map[string]TailnetState{}
+	for _, tailnet := range r.tailnetList() {
+		states[tailnet.Name] = tailnet.State()
+	}
+	return states
+}
+
+// GetTailnetStatus returns the status of every tailnet, the default one first
+func (r *Router) GetTailnetStatus() []TailscaleStatus {
+	statuses := []TailscaleStatus{}
+	for _, tailnet := range r.tailnetList() {
+		statuses = append(statuses, tailnet.Status())
+	}
+	return statuses
+}
+
+// GetTailnetConfigs returns the config of the named tailnets
+func (r *Router) GetTailnetConfigs() []utils.TailnetConfig {
+	configs := []utils.TailnetConfig{}
+	for _, tailnet := range r.tailnetList() {
+		if tailnet.Name != DefaultTailnet {
+			configs = append(configs, utils.TailnetConfig{Name: tailnet.Name, TailscaleConfig: tailnet.Config()})
+		}
+	}
+	return configs
+}
@@ -2,7 +2,9 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"time"
 	"warptail/pkg/utils"
 
@@ -10,23 +12,32 @@ import (
 )
 
 type TailscaleStatus struct {
-	Version   string           `json:"version"`
-	State     string           `json:"state"`
-	Peers     []TailscalePeers `json:"nodes"`
-	HostName  string           `json:"hostname"`
-	KeyExpiry *time.Time       `json:"key_expiry"`
-	AuthURL   string           `json:"auth_url,omitempty"`
+	Name             string           `json:"name"`
+	Version          string           `json:"version"`
+	State            string           `json:"state"`
+	Peers            []TailscalePeers `json:"nodes"`
+	HostName         string           `json:"hostname"`
+	KeyExpiry        *time.Time       `json:"key_expiry"`
+	KeyExpiryWarning bool             `json:"key_expiry_warning,omitempty"`
+	Ephemeral        bool             `json:"ephemeral,omitempty"`
+	Tags             []string         `json:"tags,omitempty"`
+	AuthURL          string           `json:"auth_url,omitempty"`
+	Supervisor       TailnetState     `json:"supervisor"`
 }
 
 type TailscalePeers struct {
-	Id        string    `json:"id"`
-	Name      string    `json:"name"`
-	HostName  string    `json:"hostname"`
-	IP        string    `json:"ip"`
-	LastSeen  time.Time `json:"last_seen"`
-	Online    bool      `json:"online"`
-	Os        string    `json:"os"`
-	KeyExpiry time.Time `json:"key_expiry"`
+	Id             string    `json:"id"`
+	Name           string    `json:"name"`
+	HostName       string    `json:"hostname"`
+	IP             string    `json:"ip"`
+	IPs            []string  `json:"ips"`
+	LastSeen       time.Time `json:"last_seen"`
+	Online         bool      `json:"online"`
+	Os             string    `json:"os"`
+	Tags           []string  `json:"tags,omitempty"`
+	ExitNode       bool      `json:"exit_node,omitempty"`
+	ExitNodeOption bool      `json:"exit_node_option,omitempty"`
+	KeyExpiry      time.Time `json:"key_expiry"`
 }
 
 func LogPrintf(format string, args ...any) {
@@ -35,107 +46,122 @@ func LogPrintf(format string, args ...any) {
 	logger.Info(formattedMessage)
 }
 
-func (r *Router) UpdateTailscale(config utils.TailscaleConfig) error {
-
-	if r.ts == nil {
-		r.ts = &tsnet.Server{
-			AuthKey:  config.AuthKey,
-			Hostname: config.Hostname,
-			UserLogf: LogPrintf,
-		}
+// newTailscaleServer builds the tsnet node, with an OAuth client secret tsnet mints
+// its own auth key for the advertised tags so no expiring key has to be configured.
+func newTailscaleServer(name string, config utils.TailscaleConfig) *tsnet.Server {
+	return &tsnet.Server{
+		AuthKey:       config.AuthKey,
+		Hostname:      config.Hostname,
+		Dir:           tailnetDir(name, config),
+		Ephemeral:     config.Ephemeral,
+		AdvertiseTags: config.Tags,
+		ClientSecret:  config.ClientSecret,
+		UserLogf:      LogPrintf,
 	}
+}
 
-	if r.ts.AuthKey != config.AuthKey || r.ts.Hostname != config.Hostname {
-		r.StopAll()
-		r.ts.Close()
-		r.ts.AuthKey = config.AuthKey
-		r.ts.Hostname = config.Hostname
+// tailscaleChanged reports whether the node has to be restarted for the new config
+func tailscaleChanged(previous, next utils.TailscaleConfig) bool {
+	previous.KeyExpiryWarning, next.KeyExpiryWarning = 0, 0
+	return !reflect.DeepEqual(previous, next)
+}
 
-		r.ts = &tsnet.Server{
-			AuthKey:  config.AuthKey,
-			Hostname: config.Hostname,
-			UserLogf: LogPrintf,
-		}
-	}
+// UpdateTailscale applies the config of the default tailnet, restarting the node
+// and its supervisor when it changed, and waits for the tailnet to come up. The
+// supervisor keeps retrying in the background when the wait times out.
+func (r *Router) UpdateTailscale(config utils.TailscaleConfig) error {
+	r.updateTailnet(DefaultTailnet, config)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(60)*time.Second)
 	defer cancel()
-	_, err := r.ts.Up(ctx)
-	if err != nil {
-		return err
-	}
-
-	// Wait for Tailscale to be fully authenticated and running
 	return r.WaitForTailscale(ctx)
 }
 
-// WaitForTailscale blocks until Tailscale backend state is "Running" or context times out
-func (r *Router) WaitForTailscale(ctx context.Context) error {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("timeout waiting for tailscale to authenticate: %w", ctx.Err())
-		case <-ticker.C:
-			c, err := r.ts.LocalClient()
-			if err != nil {
-				continue
-			}
-			status, err := c.Status(ctx)
-			if err != nil {
-				continue
-			}
-			if status.BackendState == "Running" {
-				utils.Logger.Info("Tailscale connected successfully", "hostname", r.ts.Hostname)
-				return nil
-			}
-			utils.Logger.V(1).Info("Waiting for Tailscale authentication", "state", status.BackendState)
-		}
+// GetTailScaleStatus returns the status of the default tailnet
+func (r *Router) GetTailScaleStatus() TailscaleStatus {
+	if tailnet := r.tailnet(DefaultTailnet); tailnet != nil {
+		return tailnet.Status()
 	}
+	return TailscaleStatus{Name: DefaultTailnet, Peers: []TailscalePeers{}}
 }
 
-func (r *Router) GetTailScaleStatus() TailscaleStatus {
-	c, _ := r.ts.LocalClient()
+// Status returns the node and peer status of the tailnet
+func (tailnet *Tailnet) Status() TailscaleStatus {
+	config := tailnet.Config()
+	supervisor := tailnet.State()
+	result := TailscaleStatus{
+		Name:       tailnet.Name,
+		HostName:   config.Hostname,
+		Ephemeral:  config.Ephemeral,
+		Tags:       config.Tags,
+		State:      supervisor.State,
+		Peers:      []TailscalePeers{},
+		Supervisor: supervisor,
+	}
+	ts := tailnet.Server()
+	if ts == nil {
+		return result
+	}
+	c, err := ts.LocalClient()
+	if err != nil {
+		return result
+	}
 	status, err := c.Status(context.Background())
 	if err != nil {
-		return TailscaleStatus{}
+		return result
 	}
 
-	nodes := []TailscalePeers{}
 	for _, peer := range status.Peer {
-		nodes = append(nodes, TailscalePeers{
-			Id:       string(peer.ID),
-			Name:     peer.DNSName,
-			HostName: peer.HostName,
-			IP:       peer.TailscaleIPs[0].String(),
-			LastSeen: peer.LastSeen,
-			Os:       peer.OS,
-			Online:   peer.Online,
-		})
-	}
-
-	return TailscaleStatus{
-		HostName:  r.ts.Hostname,
-		KeyExpiry: status.Self.KeyExpiry,
-		Version:   status.Version,
-		State:     status.BackendState,
-		Peers:     nodes,
-		AuthURL:   status.AuthURL,
+		result.Peers = append(result.Peers, peerInfo(peer))
+	}
+
+	if status.Self != nil {
+		result.KeyExpiry = status.Self.KeyExpiry
+		result.KeyExpiryWarning = keyExpiring(status.Self.KeyExpiry, config.KeyExpiryWindow())
+	}
+	result.Version = status.Version
+	result.State = status.BackendState
+	result.AuthURL = status.AuthURL
+	return result
+}
+
+// keyExpiring reports whether a node key expires within window, keys with expiry
+// disabled never do
+func keyExpiring(expiry *time.Time, window time.Duration) bool {
+	return expiry != nil && !expiry.IsZero() && time.Until(*expiry) < window
+}
+
+// KeyExpiry returns when the node key expires and whether it is within the warning
+// window, the time is nil when key expiry is disabled
+func (r *Router) KeyExpiry() (*time.Time, bool) {
+	ts := r.server(DefaultTailnet)
+	if ts == nil {
+		return nil, false
+	}
+	c, err := ts.LocalClient()
+	if err != nil {
+		return nil, false
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := c.Status(ctx)
+	if err != nil || status.Self == nil {
+		return nil, false
+	}
+	return status.Self.KeyExpiry, keyExpiring(status.Self.KeyExpiry, r.GetTailScaleConfig().KeyExpiryWindow())
 }
 
 func (r *Router) SaveTailScale(config utils.TailscaleConfig) {
-	r.UpdateTailscale(config)
+	if err := r.UpdateTailscale(config); err != nil {
+		utils.Logger.Info("Tailscale not connected yet, routes resume once it is running", "error", err.Error())
+	}
 	r.Save()
-	r.StartAll()
 }
 
 func (r *Router) GetTailScaleConfig() utils.TailscaleConfig {
-	return utils.TailscaleConfig{
-		AuthKey:  r.ts.AuthKey,
-		Hostname: r.ts.Hostname,
+	if tailnet := r.tailnet(DefaultTailnet); tailnet != nil {
+		return tailnet.Config()
 	}
+	return utils.TailscaleConfig{}
 }
 
 func GetTailScaleServerIp(ts *tsnet.Server) (string, error) {
@@ -147,6 +173,8 @@ func GetTailScaleServerIp(ts *tsnet.Server) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	ip := status.Self.TailscaleIPs[0].String()
-	return ip, nil
+	if status.Self == nil || len(status.Self.TailscaleIPs) == 0 {
+		return "", errors.New("tailscale node has no ip")
+	}
+	return status.Self.TailscaleIPs[0].String(), nil
 }
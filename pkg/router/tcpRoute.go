@@ -2,10 +2,13 @@ package router
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"reflect"
+	"strconv"
 	"sync"
 	"time"
 	"warptail/pkg/utils"
@@ -28,10 +31,11 @@ type TCPRoute struct {
 	status   RouterStatus
 	listener net.Listener
 
-	quit   chan struct{}
-	cancel context.CancelFunc
-	ctx    context.Context
-	wg     sync.WaitGroup
+	quit        chan struct{}
+	drainCancel chan struct{}
+	cancel      context.CancelFunc
+	ctx         context.Context
+	wg          sync.WaitGroup
 
 	activeConns sync.Map // tracks active connections for graceful shutdown
 	connCount   int64
@@ -39,14 +43,19 @@ type TCPRoute struct {
 
 	latency   time.Duration
 	latencyMu sync.RWMutex
+	// noHeartbeat leaves probing the backend to the port range the route belongs to
+	noHeartbeat bool
+
+	deps RouteDeps
 }
 
-func NewTCPRoute(config utils.RouteConfig, client *tailscale.Server) *TCPRoute {
+func NewTCPRoute(config utils.RouteConfig, client *tailscale.Server, deps RouteDeps) *TCPRoute {
 	return &TCPRoute{
 		config: config,
 		data:   utils.NewTimeSeries(time.Second, 1000),
 		status: STOPPED,
 		client: client,
+		deps:   deps,
 	}
 }
 
@@ -66,48 +75,93 @@ func (route *TCPRoute) Stats() utils.TimeSeriesData {
 	return route.data.Data
 }
 
+func (route *TCPRoute) setData(data *utils.TimeSeries) {
+	route.data = data
+}
+
+func (route *TCPRoute) disableHeartbeat() {
+	route.noHeartbeat = true
+}
+
+// Update applies a new configuration without interrupting active connections,
+// they keep flowing to the backend they were opened with while new connections
+// use the new config. The listener is only rebound when its settings change.
 func (route *TCPRoute) Update(config utils.RouteConfig) error {
-	route.Stop()
 	route.mu.Lock()
+	previous := route.config
 	route.config = config
+	if route.status != RUNNING || route.listener == nil || !listenerChanged(previous, config) {
+		route.mu.Unlock()
+		return nil
+	}
+
+	route.listener.Close()
+	listener, err := route.listen()
+	if err != nil {
+		route.listener = nil
+		route.mu.Unlock()
+		route.Stop()
+		return err
+	}
+	route.listener = listener
+	route.wg.Add(1)
+	go route.acceptLoop(listener)
 	route.mu.Unlock()
-	return route.Start()
+	return nil
+}
+
+// listenerChanged reports whether the listener must be rebound for the new config
+func listenerChanged(previous, next utils.RouteConfig) bool {
+	return previous.IsReverse() != next.IsReverse() ||
+		previous.ListenNetwork() != next.ListenNetwork() ||
+		previous.ListenAddr() != next.ListenAddr() ||
+		!reflect.DeepEqual(previous.TLS, next.TLS) ||
+		!reflect.DeepEqual(previous.ProxyProtocol, next.ProxyProtocol)
 }
 
+func (route *TCPRoute) drainTimeout() time.Duration {
+	return time.Duration(route.config.DrainTimeout) * time.Second
+}
+
+// Stop closes the listener, with a drain timeout configured active connections are
+// left to finish and the route reports DRAINING until they close or the timeout expires.
 func (route *TCPRoute) Stop() error {
 	route.mu.Lock()
 	if route.status != RUNNING {
 		route.mu.Unlock()
 		return fmt.Errorf("route not running")
 	}
+	drainTimeout := route.drainTimeout()
+	draining := drainTimeout > 0 && route.ActiveConnections() > 0
 	route.status = STOPPING
-
-	// Cancel context to stop all dials
-	if route.cancel != nil {
-		route.cancel()
+	if draining {
+		route.status = DRAINING
 	}
 
 	// Close listener to stop accepting new connections
 	if route.listener != nil {
 		route.listener.Close()
+		route.listener = nil
 	}
 
-	// Close all active connections
-	route.activeConns.Range(func(key, value any) bool {
-		if conn, ok := value.(net.Conn); ok {
-			conn.Close()
-		}
-		return true
-	})
-
 	// Signal all goroutines to stop
 	close(route.quit)
+	if draining {
+		route.drainCancel = make(chan struct{})
+		go route.drain(drainTimeout, route.drainCancel)
+	}
 	route.mu.Unlock()
 
-	// Wait for all goroutines to finish
+	// Wait for the accept loop and heartbeat to finish
 	route.wg.Wait()
 
+	if draining {
+		utils.Logger.Info("Draining TCP route", "port", route.config.Port, "connections", route.ActiveConnections(), "timeout", drainTimeout.String())
+		return nil
+	}
+
 	route.mu.Lock()
+	route.closeConnections()
 	route.status = STOPPED
 	route.mu.Unlock()
 
@@ -115,6 +169,51 @@ func (route *TCPRoute) Stop() error {
 	return nil
 }
 
+// drain waits for active connections to finish, closing any left when the timeout expires
+func (route *TCPRoute) drain(timeout time.Duration, cancel chan struct{}) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			if route.ActiveConnections() > 0 {
+				continue
+			}
+		case <-deadline.C:
+		}
+
+		route.mu.Lock()
+		defer route.mu.Unlock()
+		// The route was restarted while draining and adopted the connections
+		if route.status != DRAINING {
+			return
+		}
+		route.closeConnections()
+		route.status = STOPPED
+		utils.Logger.Info("Stopped TCP route", "port", route.config.Port)
+		return
+	}
+}
+
+// closeConnections cancels pending dials and closes every active connection,
+// must be called with the lock held
+func (route *TCPRoute) closeConnections() {
+	if route.cancel != nil {
+		route.cancel()
+	}
+	route.activeConns.Range(func(key, value any) bool {
+		if conn, ok := value.(net.Conn); ok {
+			conn.Close()
+		}
+		return true
+	})
+}
+
 func (route *TCPRoute) Start() error {
 	route.mu.Lock()
 	if route.status == RUNNING {
@@ -123,81 +222,146 @@ func (route *TCPRoute) Start() error {
 		route.mu.Lock()
 	}
 
+	// Connections still draining are adopted by the restarted route
+	if route.status == DRAINING {
+		close(route.drainCancel)
+	} else {
+		route.ctx, route.cancel = context.WithCancel(context.Background())
+	}
+
 	route.status = STARTING
 	route.quit = make(chan struct{})
-	route.ctx, route.cancel = context.WithCancel(context.Background())
 
-	laddr := fmt.Sprintf(":%d", route.config.Port)
+	// Passthrough routes share the HTTPS listener and receive connections from the SNIListener
+	if route.config.Type == utils.TLS_PASSTHROUGH {
+		if !route.deps.Passthrough {
+			route.status = STOPPED
+			route.mu.Unlock()
+			return fmt.Errorf("tls-passthrough requires the ACME HTTPS listener, enable the certificate manager")
+		}
+		route.startHeartbeat()
+		route.status = RUNNING
+		route.mu.Unlock()
+		return nil
+	}
 
-	var err error
-	route.listener, err = net.Listen("tcp", laddr)
+	listener, err := route.listen()
 	if err != nil {
 		route.status = STOPPED
 		route.mu.Unlock()
 		return err
 	}
+	route.listener = listener
 
-	route.wg.Add(2)
-	go route.acceptLoop()
-	go route.runHeartbeat()
+	route.wg.Add(1)
+	go route.acceptLoop(listener)
+	route.startHeartbeat()
 
 	route.status = RUNNING
 	route.mu.Unlock()
 	return nil
 }
 
-func (route *TCPRoute) acceptLoop() {
+// listen opens the route listener, must be called with the lock held
+func (route *TCPRoute) listen() (net.Listener, error) {
+	listener, err := listenTCP(route.config, route.client)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := acceptProxyProtocol(listener, route.config.ProxyProtocol)
+	if err == nil && route.config.TLS != nil {
+		var tlsConfig *tls.Config
+		if tlsConfig, err = tcpTLSConfig(route.config, route.deps.CertificateManager); err == nil {
+			wrapped = tls.NewListener(wrapped, tlsConfig)
+		}
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+func (route *TCPRoute) acceptLoop(listener net.Listener) {
 	defer route.wg.Done()
 
 	for {
-		select {
-		case <-route.quit:
-			return
-		default:
-		}
-
-		conn, err := route.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			select {
-			case <-route.quit:
+			if errors.Is(err, net.ErrClosed) {
 				return
-			default:
-				log.Println("TCP accept error:", err)
-				continue
 			}
+			log.Println("TCP accept error:", err)
+			continue
 		}
 
-		// Track connection
-		connID := fmt.Sprintf("%p", conn)
-		route.activeConns.Store(connID, conn)
-
-		route.connCountMu.Lock()
-		route.connCount++
-		route.connCountMu.Unlock()
+		route.serveConn(conn)
+	}
+}
 
-		go route.handleConnection(conn, connID)
+// HandleConn proxies a connection accepted by another listener through the route
+func (route *TCPRoute) HandleConn(conn net.Conn) {
+	if route.Status() != RUNNING {
+		conn.Close()
+		return
 	}
+	route.serveConn(conn)
+}
+
+// trackedConn is an active client connection with its traffic stats
+type trackedConn struct {
+	net.Conn
+	stats *connStats
+}
+
+func (route *TCPRoute) serveConn(conn net.Conn) {
+	// Track connection
+	stats := newConnStats(route.Config().Port, conn.RemoteAddr().String(), route.backendAddr())
+	route.activeConns.Store(stats.id, &trackedConn{Conn: conn, stats: stats})
+
+	route.connCountMu.Lock()
+	route.connCount++
+	route.connCountMu.Unlock()
+
+	go route.handleConnection(conn, stats)
 }
 
-func (route *TCPRoute) handleConnection(clientConn net.Conn, connID string) {
+func (route *TCPRoute) handleConnection(clientConn net.Conn, stats *connStats) {
 	defer func() {
 		clientConn.Close()
-		route.activeConns.Delete(connID)
+		route.activeConns.Delete(stats.id)
 
 		route.connCountMu.Lock()
 		route.connCount--
 		route.connCountMu.Unlock()
 	}()
 
-	// Connect to backend through Tailscale
-	backendAddr := route.backendAddr()
-	backendConn, err := route.client.Dial(route.ctx, "tcp", backendAddr)
+	if tlsConn, ok := clientConn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(route.ctx, tlsHandshakeTimeout)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			utils.Logger.V(1).Info("TLS handshake failed", "client", clientConn.RemoteAddr().String(), "error", err.Error())
+			return
+		}
+	}
+
+	// Connect to backend, through Tailscale unless the route is reversed
+	backendAddr := stats.backend
+	backendConn, err := backendDialer(route.Config(), route.client, route.deps)(route.ctx, "tcp", backendAddr)
 	if err != nil {
 		utils.Logger.Error(err, "remote connection failed")
 		return
 	}
 	defer backendConn.Close()
 
+	if settings := route.Config().ProxyProtocol; settings != nil && settings.Send > 0 {
+		if err := writeProxyHeader(backendConn, settings.Send, clientConn.RemoteAddr(), clientConn.LocalAddr()); err != nil {
+			utils.Logger.Error(err, "unable to send proxy protocol header", "backend", backendAddr)
+			return
+		}
+	}
+
 	// Bidirectional copy with stats tracking
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -205,63 +369,63 @@ func (route *TCPRoute) handleConnection(clientConn net.Conn, connID string) {
 	// Client -> Backend (sent data)
 	go func() {
 		defer wg.Done()
-		route.copyWithStats(backendConn, clientConn, true)
+		route.copyConn(backendConn, clientConn, stats, true)
 	}()
 
 	// Backend -> Client (received data)
 	go func() {
 		defer wg.Done()
-		route.copyWithStats(clientConn, backendConn, false)
+		route.copyConn(clientConn, backendConn, stats, false)
 	}()
 
 	wg.Wait()
 }
 
-func (route *TCPRoute) copyWithStats(dst, src net.Conn, isSent bool) int64 {
+func (route *TCPRoute) copyWithStats(dst, src net.Conn, stats *connStats, isSent bool) int64 {
 	buf := make([]byte, tcpBufferSize)
 	var totalBytes int64
 
 	for {
-		select {
-		case <-route.quit:
-			return totalBytes
-		default:
-		}
-
 		n, readErr := src.Read(buf)
 		if n > 0 {
 			written, writeErr := dst.Write(buf[:n])
 			if written > 0 {
 				totalBytes += int64(written)
 				// Log stats in real-time as data flows
-				if isSent {
-					route.data.LogSent(uint64(written))
-				} else {
-					route.data.LogRecived(uint64(written))
-				}
+				route.logTraffic(stats, uint64(written), isSent)
 			}
 			if writeErr != nil {
 				return totalBytes
 			}
 		}
 		if readErr != nil {
-			if readErr != io.EOF {
-				// Only log if it's not a normal close
-				select {
-				case <-route.quit:
-				default:
-					// Connection closed by peer is normal
-				}
-			}
 			return totalBytes
 		}
 	}
 }
 
+func (route *TCPRoute) logTraffic(stats *connStats, n uint64, isSent bool) {
+	if isSent {
+		route.data.LogSent(n)
+		stats.logSent(n)
+	} else {
+		route.data.LogRecived(n)
+		stats.logReceived(n)
+	}
+}
+
 func (route *TCPRoute) backendAddr() string {
-	route.mu.RLock()
-	defer route.mu.RUnlock()
-	return fmt.Sprintf("%s:%d", route.config.Machine.Address, route.config.Machine.Port)
+	config := route.Config()
+	return net.JoinHostPort(machineHost(config, route.client), strconv.Itoa(int(config.Machine.Port)))
+}
+
+// startHeartbeat runs the latency probe, must be called with the lock held
+func (route *TCPRoute) startHeartbeat() {
+	if route.noHeartbeat {
+		return
+	}
+	route.wg.Add(1)
+	go route.runHeartbeat()
 }
 
 func (route *TCPRoute) runHeartbeat() {
@@ -282,13 +446,20 @@ func (route *TCPRoute) runHeartbeat() {
 func (route *TCPRoute) measureLatency() {
 	backendAddr := route.backendAddr()
 
+	route.mu.RLock()
+	ctx := route.ctx
+	route.mu.RUnlock()
+	if ctx == nil {
+		return
+	}
+
 	start := time.Now()
-	dialCtx, cancel := context.WithTimeout(route.ctx, 5*time.Second)
+	dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	conn, err := route.client.Dial(dialCtx, "tcp", backendAddr)
+	conn, err := backendDialer(route.Config(), route.client, route.deps)(dialCtx, "tcp", backendAddr)
+	route.latencyMu.Lock()
+	defer route.latencyMu.Unlock()
 	if err != nil {
-		route.latencyMu.Lock()
-		defer route.latencyMu.Unlock()
 		route.latency = -1
 		return
 	}
@@ -308,3 +479,22 @@ func (route *TCPRoute) ActiveConnections() int64 {
 	defer route.connCountMu.Unlock()
 	return route.connCount
 }
+
+// Connections lists the active client connections
+func (route *TCPRoute) Connections() []Connection {
+	connections := []Connection{}
+	route.activeConns.Range(func(_, value any) bool {
+		connections = append(connections, value.(*trackedConn).stats.connection())
+		return true
+	})
+	return connections
+}
+
+// CloseConnection closes a single client connection, reporting whether it was found
+func (route *TCPRoute) CloseConnection(id string) bool {
+	value, ok := route.activeConns.Load(id)
+	if ok {
+		value.(*trackedConn).Close()
+	}
+	return ok
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// spliceChunkSize bounds each zero-copy transfer on busy connections so the
+	// byte counters are updated as data flows rather than when the connection closes
+	spliceChunkSize = 256 * 1024
+	// spliceFlushInterval bounds each transfer in time for quiet connections, a
+	// read deadline ends the splice so the bytes moved so far are counted
+	spliceFlushInterval = time.Second
+)
+
+// copyConn copies src to dst, using the kernel fast path when both sides are
+// plain TCP sockets and the buffered copy for TLS, PROXY protocol and tailnet conns.
+func (route *TCPRoute) copyConn(dst, src net.Conn, stats *connStats, isSent bool) int64 {
+	dstTCP, dstOk := dst.(*net.TCPConn)
+	srcTCP, srcOk := src.(*net.TCPConn)
+	if !dstOk || !srcOk {
+		return route.copyWithStats(dst, src, stats, isSent)
+	}
+	return route.spliceWithStats(dstTCP, srcTCP, stats, isSent)
+}
+
+// spliceWithStats copies between two TCP sockets in chunks, on Linux ReadFrom
+// moves the data with splice(2) without passing through user space.
+func (route *TCPRoute) spliceWithStats(dst, src *net.TCPConn, stats *connStats, isSent bool) int64 {
+	var totalBytes int64
+	defer src.SetReadDeadline(time.Time{})
+	for {
+		src.SetReadDeadline(time.Now().Add(spliceFlushInterval))
+		n, err := dst.ReadFrom(io.LimitReader(src, spliceChunkSize))
+		if n > 0 {
+			totalBytes += n
+			route.logTraffic(stats, uint64(n), isSent)
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		// A short chunk means src reached EOF
+		if err != nil || n < spliceChunkSize {
+			return totalBytes
+		}
+	}
+}
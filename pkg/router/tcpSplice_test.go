@@ -0,0 +1,124 @@
+//go:build unix
+
+package router
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+	"warptail/pkg/utils"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		tb.Fatal("accept failed")
+	}
+	return client.(*net.TCPConn), server.(*net.TCPConn)
+}
+
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// benchmarkCopy pushes b.N chunks from a client socket through the route copy
+// into a backend socket, reporting throughput and process CPU time per chunk
+func benchmarkCopy(b *testing.B, proxy func(route *TCPRoute, dst, src *net.TCPConn, stats *connStats)) {
+	route := NewTCPRoute(utils.RouteConfig{Type: utils.TCP}, nil, RouteDeps{})
+	stats := newConnStats(0, "client", "backend")
+	client, proxyIn := tcpPair(b)
+	proxyOut, backend := tcpPair(b)
+	defer client.Close()
+	defer backend.Close()
+
+	chunk := make([]byte, 64*1024)
+	total := int64(len(chunk)) * int64(b.N)
+	go func() {
+		proxy(route, proxyOut, proxyIn, stats)
+		proxyOut.Close()
+		proxyIn.Close()
+	}()
+	drained := make(chan int64)
+	go func() {
+		n, _ := io.Copy(io.Discard, backend)
+		drained <- n
+	}()
+
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	start := cpuTime()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	client.CloseWrite()
+	if n := <-drained; n != total {
+		b.Fatalf("backend received %d of %d bytes", n, total)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(cpuTime()-start)/float64(b.N), "cpu-ns/op")
+	if sent := stats.sent.Load(); sent != uint64(total) {
+		b.Fatalf("counted %d of %d bytes", sent, total)
+	}
+}
+
+func BenchmarkTCPCopyBuffered(b *testing.B) {
+	benchmarkCopy(b, func(route *TCPRoute, dst, src *net.TCPConn, stats *connStats) {
+		route.copyWithStats(dst, src, stats, true)
+	})
+}
+
+func BenchmarkTCPCopySplice(b *testing.B) {
+	benchmarkCopy(b, func(route *TCPRoute, dst, src *net.TCPConn, stats *connStats) {
+		route.spliceWithStats(dst, src, stats, true)
+	})
+}
+
+// A quiet connection must be counted while it is open, not only once it closes
+func TestSpliceCountsQuietConnection(t *testing.T) {
+	route := NewTCPRoute(utils.RouteConfig{Type: utils.TCP}, nil, RouteDeps{})
+	stats := newConnStats(0, "client", "backend")
+	client, proxyIn := tcpPair(t)
+	proxyOut, backend := tcpPair(t)
+	defer client.Close()
+	defer backend.Close()
+	defer proxyIn.Close()
+	defer proxyOut.Close()
+	go route.spliceWithStats(proxyOut, proxyIn, stats, true)
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(backend, buf); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * spliceFlushInterval)
+	for stats.sent.Load() != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("counted %d bytes on an open connection, want 4", stats.sent.Load())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,59 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+	"warptail/pkg/utils"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tcpTLSConfig builds the listener TLS config for a route terminating TLS
+func tcpTLSConfig(config utils.RouteConfig, certificates *autocert.Manager) (*tls.Config, error) {
+	settings := config.TLS
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if settings.UseACME() {
+		if certificates == nil {
+			return nil, fmt.Errorf("tls for %s needs a certificate file or acme to be enabled", config.Domain)
+		}
+		domain := config.Domain
+		tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// Clients of non HTTP protocols often skip SNI, fall back to the route domain
+			if hello.ServerName == "" {
+				hello.ServerName = domain
+			}
+			return certificates.GetCertificate(hello)
+		}
+	} else {
+		certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if len(settings.ClientCAFile) > 0 {
+		pem, err := os.ReadFile(settings.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", settings.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if settings.VerifyClient {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
@@ -6,27 +6,39 @@ import (
 	"log"
 	"net"
 	"net/netip"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 	"warptail/pkg/utils"
 
+	"github.com/pires/go-proxyproto"
 	"tailscale.com/tailcfg"
 	tailscale "tailscale.com/tsnet"
 )
 
 const (
-	udpBufferSize        = 65535
-	udpSessionTimeout    = 30 * time.Second
-	udpHeartbeatInterval = 5 * time.Second
+	defaultUDPBufferSize     = 65535
+	defaultUDPSessionTimeout = 30 * time.Second
+	udpCleanupInterval       = 10 * time.Second
+	udpHeartbeatInterval     = 5 * time.Second
+	udpSessionDialTimeout    = 5 * time.Second
+	// udpSessionQueue bounds the packets held for a session socket, packets
+	// arriving while the backend is dialed or a write is slow are dropped past it
+	udpSessionQueue = 64
 )
 
 // udpSession represents a client session for UDP NAT traversal.
-// Each client gets its own session with a dedicated backend connection
-// to maintain consistent source ports for protocols like QUIC.
+// With session sockets enabled each client gets its own backend connection
+// to maintain consistent source ports for protocols like QUIC. The connection
+// is dialed and written by the session goroutine, packets reach it through
+// pending so a slow dial never holds up the shared reader.
 type udpSession struct {
 	clientAddr net.Addr
 	lastSeen   atomic.Value // stores time.Time
+	stats      *connStats
+	pending    chan []byte
+	done       chan struct{}
 }
 
 // UDPRoute handles UDP traffic proxying through Tailscale.
@@ -41,24 +53,31 @@ type UDPRoute struct {
 	status     RouterStatus
 	listener   net.PacketConn
 	remote     net.PacketConn
-	remoteAddr *net.UDPAddr
+	remoteAddr atomic.Pointer[net.UDPAddr]
 	tsNodeAddr string
 
 	quit chan struct{}
 	wg   sync.WaitGroup
 
-	sessions sync.Map
+	sessions     sync.Map
+	sessionCount atomic.Int64
+	proxyPolicy  proxyproto.PolicyFunc
 
 	latency   time.Duration
 	latencyMu sync.RWMutex
+	// noHeartbeat leaves probing the backend to the port range the route belongs to
+	noHeartbeat bool
+
+	deps RouteDeps
 }
 
-func NewUDPRoute(config utils.RouteConfig, client *tailscale.Server) *UDPRoute {
+func NewUDPRoute(config utils.RouteConfig, client *tailscale.Server, deps RouteDeps) *UDPRoute {
 	return &UDPRoute{
 		config: config,
 		data:   utils.NewTimeSeries(time.Second, 1000),
 		status: STOPPED,
 		client: client,
+		deps:   deps,
 	}
 }
 
@@ -78,11 +97,26 @@ func (route *UDPRoute) Stats() utils.TimeSeriesData {
 	return route.data.Data
 }
 
+func (route *UDPRoute) setData(data *utils.TimeSeries) {
+	route.data = data
+}
+
+func (route *UDPRoute) disableHeartbeat() {
+	route.noHeartbeat = true
+}
+
+// Update restarts a running route with the new config, a stopped one stays stopped
 func (route *UDPRoute) Update(config utils.RouteConfig) error {
-	route.Stop()
+	running := route.Status() == RUNNING
+	if running {
+		route.Stop()
+	}
 	route.mu.Lock()
 	route.config = config
 	route.mu.Unlock()
+	if !running {
+		return nil
+	}
 	return route.Start()
 }
 
@@ -94,22 +128,28 @@ func (route *UDPRoute) Stop() error {
 	}
 	route.status = STOPPING
 
-	// Close connections to unblock readers
+	// Signal all goroutines to stop before closing the sockets they read, the
+	// fields stay set until the goroutines are gone so none sees a nil conn
+	close(route.quit)
 	if route.listener != nil {
 		route.listener.Close()
 	}
 	if route.remote != nil {
 		route.remote.Close()
 	}
-
-	// Signal all goroutines to stop
-	close(route.quit)
 	route.mu.Unlock()
 
 	// Wait for all goroutines to finish
 	route.wg.Wait()
 
+	// Closing the session sockets ends their readers
+	route.sessions.Range(func(key, _ any) bool {
+		route.removeSession(key)
+		return true
+	})
+
 	route.mu.Lock()
+	route.remote = nil
 	route.status = STOPPED
 	route.mu.Unlock()
 
@@ -128,59 +168,134 @@ func (route *UDPRoute) Start() error {
 	route.status = STARTING
 	route.quit = make(chan struct{})
 
-	laddr := fmt.Sprintf(":%d", route.config.Port)
-
 	var err error
 
-	route.listener, err = net.ListenPacket("udp", laddr)
+	route.proxyPolicy, err = proxyPolicy(route.config.ProxyProtocol)
 	if err != nil {
 		route.status = STOPPED
 		route.mu.Unlock()
 		return err
 	}
 
-	tsIP, err := GetTailScaleServerIp(route.client)
+	route.listener, err = route.listenPacket()
 	if err != nil {
-		route.listener.Close()
 		route.status = STOPPED
 		route.mu.Unlock()
-		log.Println("Failed to get Tailscale node address:", err)
 		return err
 	}
-	remoteAddr := fmt.Sprintf("%s:%d", tsIP, route.config.Machine.Port)
 
-	route.remote, err = route.client.ListenPacket("udp", remoteAddr)
-	if err != nil {
-		route.listener.Close()
-		route.status = STOPPED
-		route.mu.Unlock()
-		utils.Logger.Error(err, "Failed to create Tailscale UDP socket:")
-		return err
-	}
+	// Without session sockets every client shares a single backend socket
+	if !route.sessionSockets() {
+		route.remote, err = route.listenRemote()
+		if err != nil {
+			route.listener.Close()
+			route.status = STOPPED
+			route.mu.Unlock()
+			return err
+		}
 
-	route.remoteAddr, err = net.ResolveUDPAddr("udp", route.backendAddr())
-	if err != nil {
-		route.listener.Close()
-		route.remote.Close()
-		route.status = STOPPED
-		route.mu.Unlock()
-		log.Fatal("Failed to resolve game server address:", err)
+		// Node backends resolve once the node cache has loaded, see resolveRemote
+		if len(route.config.Machine.NodeName) == 0 {
+			remoteAddr, err := net.ResolveUDPAddr("udp", route.backendAddr())
+			if err != nil {
+				route.listener.Close()
+				route.remote.Close()
+				route.status = STOPPED
+				route.mu.Unlock()
+				return fmt.Errorf("unable to resolve udp backend %s: %w", route.backendAddr(), err)
+			}
+			route.remoteAddr.Store(remoteAddr)
+		}
+
+		route.wg.Add(1)
+		go route.serve()
 	}
 
-	route.wg.Add(4)
+	route.wg.Add(2)
 	go route.reader()
-	go route.serve()
 	go route.cleanupStaleSessions()
-	go route.runHeartbeat()
+	if !route.noHeartbeat {
+		route.wg.Add(1)
+		go route.runHeartbeat()
+	}
 
 	route.status = RUNNING
 	route.mu.Unlock()
+	route.resolveRemote()
 	return nil
 }
 
+// listenPacket opens the client facing socket, on the host for forward routes
+// and on the tailnet node for reverse routes
+func (route *UDPRoute) listenPacket() (net.PacketConn, error) {
+	if !route.config.IsReverse() {
+		return net.ListenPacket(route.config.ListenNetwork(), route.config.ListenAddr())
+	}
+	tsIP, err := GetTailScaleServerIp(route.client)
+	if err != nil {
+		log.Println("Failed to get Tailscale node address:", err)
+		return nil, err
+	}
+	return route.client.ListenPacket("udp", net.JoinHostPort(tsIP, strconv.Itoa(route.config.Port)))
+}
+
+// listenRemote opens the shared backend socket, routes not dialing through the
+// tailnet reach the backend from the host
+func (route *UDPRoute) listenRemote() (net.PacketConn, error) {
+	if !route.config.DialsTailnet() {
+		return net.ListenPacket("udp", ":0")
+	}
+	tsIP, err := GetTailScaleServerIp(route.client)
+	if err != nil {
+		log.Println("Failed to get Tailscale node address:", err)
+		return nil, err
+	}
+	remoteAddr := fmt.Sprintf("%s:%d", tsIP, route.config.Machine.Port)
+
+	remote, err := route.client.ListenPacket("udp", remoteAddr)
+	if err != nil {
+		utils.Logger.Error(err, "Failed to create Tailscale UDP socket:")
+		return nil, err
+	}
+	return remote, nil
+}
+
+func (route *UDPRoute) sessionTimeout() time.Duration {
+	if settings := route.config.UDP; settings != nil && settings.SessionTimeout > 0 {
+		return time.Duration(settings.SessionTimeout) * time.Second
+	}
+	return defaultUDPSessionTimeout
+}
+
+func (route *UDPRoute) bufferSize() int {
+	if settings := route.config.UDP; settings != nil && settings.BufferSize > 0 {
+		return settings.BufferSize
+	}
+	return defaultUDPBufferSize
+}
+
+func (route *UDPRoute) maxSessions() int {
+	if settings := route.config.UDP; settings != nil {
+		return settings.MaxSessions
+	}
+	return 0
+}
+
+// sessionSockets reports whether each session dials its own backend socket, always
+// the case for dialers other than tailscale and direct as they have no shared socket
+func (route *UDPRoute) sessionSockets() bool {
+	switch route.config.DialerType() {
+	case utils.TailscaleDialer, utils.DirectDialer:
+		return route.config.UDP != nil && route.config.UDP.SessionSockets
+	}
+	return true
+}
+
+// serve fans replies from the shared Tailscale socket out to every live session
 func (route *UDPRoute) serve() {
 	defer route.wg.Done()
-	buf := make([]byte, udpBufferSize)
+	buf := make([]byte, route.bufferSize())
+	timeout := route.sessionTimeout()
 	for {
 		select {
 		case <-route.quit:
@@ -207,7 +322,7 @@ func (route *UDPRoute) serve() {
 			s := v.(*udpSession)
 			lastSeen := s.lastSeen.Load().(time.Time)
 
-			if time.Since(lastSeen) > udpSessionTimeout {
+			if time.Since(lastSeen) > timeout {
 				return true
 			}
 
@@ -216,6 +331,7 @@ func (route *UDPRoute) serve() {
 				log.Printf("Public write error to %s: %v", s.clientAddr, err)
 			} else {
 				route.data.LogRecived(uint64(len(data)))
+				s.stats.logReceived(uint64(len(data)))
 			}
 			return true
 		})
@@ -223,12 +339,129 @@ func (route *UDPRoute) serve() {
 }
 
 func (route *UDPRoute) backendAddr() string {
-	return fmt.Sprintf("%s:%d", route.config.Machine.Address, route.config.Machine.Port)
+	return net.JoinHostPort(machineHost(route.config, route.client), strconv.Itoa(int(route.config.Machine.Port)))
+}
+
+// resolveRemote follows a backend node whose tailnet address changed, it reads the
+// node cache only and leaves the address unset while the node is not known yet
+func (route *UDPRoute) resolveRemote() {
+	config := route.Config()
+	machine := config.Machine
+	if len(machine.NodeName) == 0 {
+		return
+	}
+	host := machineHost(config, route.client)
+	if host == machine.NodeName || len(host) == 0 {
+		return
+	}
+	if remoteAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(int(machine.Port)))); err == nil {
+		route.remoteAddr.Store(remoteAddr)
+	}
+}
+
+// runSession dials the session's backend socket and writes the queued packets
+// to it until the session is removed
+func (route *UDPRoute) runSession(key string, session *udpSession) {
+	ctx, cancel := context.WithTimeout(context.Background(), udpSessionDialTimeout)
+	go func() {
+		select {
+		case <-session.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	backend, err := backendDialer(route.config, route.client, route.deps)(ctx, "udp", route.backendAddr())
+	cancel()
+	if err != nil {
+		log.Println("Failed to create backend session socket:", err)
+		if route.sessions.CompareAndDelete(key, session) {
+			route.sessionCount.Add(-1)
+			close(session.done)
+		}
+		return
+	}
+	defer backend.Close()
+	go route.serveSession(session, backend)
+
+	for {
+		select {
+		case <-session.done:
+			return
+		case payload := <-session.pending:
+			if _, err := backend.Write(payload); err != nil {
+				log.Println("Tailscale write error:", err)
+				continue
+			}
+			route.data.LogSent(uint64(len(payload)))
+			session.stats.logSent(uint64(len(payload)))
+		}
+	}
+}
+
+// serveSession forwards replies from a session's dedicated backend socket to its client
+func (route *UDPRoute) serveSession(session *udpSession, backend net.Conn) {
+	buf := make([]byte, route.bufferSize())
+	for {
+		n, err := backend.Read(buf)
+		if err != nil {
+			return
+		}
+		session.lastSeen.Store(time.Now())
+		if _, err := route.listener.WriteTo(buf[:n], session.clientAddr); err != nil {
+			log.Printf("Public write error to %s: %v", session.clientAddr, err)
+			continue
+		}
+		route.data.LogRecived(uint64(n))
+		session.stats.logReceived(uint64(n))
+	}
+}
+
+// session returns the session for a client, creating it unless the session limit is reached
+func (route *UDPRoute) session(clientAddr, sourceAddr net.Addr) (*udpSession, bool) {
+	key := clientAddr.String()
+	if existing, ok := route.sessions.Load(key); ok {
+		session := existing.(*udpSession)
+		session.lastSeen.Store(time.Now())
+		return session, true
+	}
+
+	if max := route.maxSessions(); max > 0 && route.sessionCount.Load() >= int64(max) {
+		utils.Logger.V(1).Info("UDP session limit reached, dropping packet", "port", route.config.Port, "client", key)
+		return nil, false
+	}
+
+	session := &udpSession{
+		clientAddr: clientAddr,
+		stats:      newConnStats(route.config.Port, sourceAddr.String(), route.backendAddr()),
+	}
+	session.lastSeen.Store(time.Now())
+
+	route.sessions.Store(key, session)
+	route.sessionCount.Add(1)
+	if route.sessionSockets() {
+		session.pending = make(chan []byte, udpSessionQueue)
+		session.done = make(chan struct{})
+		go route.runSession(key, session)
+	}
+	return session, true
+}
+
+func (route *UDPRoute) removeSession(key any) bool {
+	value, ok := route.sessions.LoadAndDelete(key)
+	if !ok {
+		return false
+	}
+	route.sessionCount.Add(-1)
+	if session := value.(*udpSession); session.done != nil {
+		close(session.done)
+	}
+	return true
 }
 
 func (route *UDPRoute) cleanupStaleSessions() {
 	defer route.wg.Done()
-	ticker := time.NewTicker(10 * time.Second)
+	timeout := route.sessionTimeout()
+	ticker := time.NewTicker(min(udpCleanupInterval, timeout))
 	defer ticker.Stop()
 	for {
 		select {
@@ -238,8 +471,8 @@ func (route *UDPRoute) cleanupStaleSessions() {
 			route.sessions.Range(func(key, value any) bool {
 				s := value.(*udpSession)
 				lastSeen := s.lastSeen.Load().(time.Time)
-				if time.Since(lastSeen) > udpSessionTimeout {
-					route.sessions.Delete(key)
+				if time.Since(lastSeen) > timeout {
+					route.removeSession(key)
 					log.Printf("Session expired: %s", key)
 				}
 				return true
@@ -250,7 +483,7 @@ func (route *UDPRoute) cleanupStaleSessions() {
 
 func (route *UDPRoute) reader() {
 	defer route.wg.Done()
-	buf := make([]byte, udpBufferSize)
+	buf := make([]byte, route.bufferSize())
 	for {
 		select {
 		case <-route.quit:
@@ -269,23 +502,75 @@ func (route *UDPRoute) reader() {
 			}
 		}
 
-		session := &udpSession{
-			clientAddr: clientAddr,
+		payload, sourceAddr, ok := route.proxyProtocol(buf[:n], clientAddr)
+		if !ok {
+			continue
 		}
-		session.lastSeen.Store(time.Now())
 
-		// Load or store atomically - if exists, update lastSeen
-		if existing, loaded := route.sessions.LoadOrStore(clientAddr.String(), session); loaded {
-			existing.(*udpSession).lastSeen.Store(time.Now())
+		session, ok := route.session(clientAddr, sourceAddr)
+		if !ok {
+			continue
+		}
+
+		if settings := route.config.ProxyProtocol; settings != nil && settings.Send > 0 {
+			payload, err = proxyDatagram(payload, sourceAddr, route.listener.LocalAddr())
+			if err != nil {
+				log.Println("Proxy protocol header error:", err)
+				continue
+			}
 		}
 
-		_, err = route.remote.WriteTo(buf[:n], route.remoteAddr)
+		if session.pending != nil {
+			// The buffer is reused for the next read, the session gets its own copy
+			select {
+			case session.pending <- append([]byte(nil), payload...):
+			default:
+				utils.Logger.V(1).Info("UDP session queue full, dropping packet", "port", route.config.Port, "client", clientAddr.String())
+			}
+			continue
+		}
+		remoteAddr := route.remoteAddr.Load()
+		if remoteAddr == nil {
+			route.resolveRemote()
+			if remoteAddr = route.remoteAddr.Load(); remoteAddr == nil {
+				utils.Logger.V(1).Info("UDP backend node not resolved yet, dropping packet", "port", route.config.Port, "node", route.config.Machine.NodeName)
+				continue
+			}
+		}
+		_, err = route.remote.WriteTo(payload, remoteAddr)
 		if err != nil {
 			log.Println("Tailscale write error:", err)
 		} else {
 			route.data.LogSent(uint64(n))
+			session.stats.logSent(uint64(n))
+		}
+	}
+}
+
+// proxyProtocol strips an accepted PROXY protocol header from a datagram, returning
+// the payload and the original client address. Malformed headers and headers from
+// untrusted sources drop the packet, like the TCP listener rejects them.
+func (route *UDPRoute) proxyProtocol(packet []byte, clientAddr net.Addr) ([]byte, net.Addr, bool) {
+	settings := route.config.ProxyProtocol
+	if settings == nil || !settings.Accept {
+		return packet, clientAddr, true
+	}
+	if !trustedProxy(clientAddr, route.proxyPolicy) {
+		if hasProxyHeader(packet) {
+			utils.Logger.V(1).Info("dropping proxy protocol header from untrusted source", "source", clientAddr.String())
+			return nil, nil, false
 		}
+		return packet, clientAddr, true
+	}
+	source, payload, err := readProxyDatagram(packet)
+	if err != nil {
+		log.Printf("Invalid proxy protocol header from %s: %v", clientAddr, err)
+		return nil, nil, false
 	}
+	if source == nil {
+		source = clientAddr
+	}
+	return payload, source, true
 }
 
 func (route *UDPRoute) runHeartbeat() {
@@ -298,6 +583,7 @@ func (route *UDPRoute) runHeartbeat() {
 		case <-route.quit:
 			return
 		case <-ticker.C:
+			route.resolveRemote()
 			route.measureLatency()
 		}
 	}
@@ -306,6 +592,15 @@ func (route *UDPRoute) runHeartbeat() {
 // Since UDP is connectionless, we  measureLatency pings the backend machine to measure latency
 
 func (route *UDPRoute) measureLatency() {
+	config := route.Config()
+	// Backends not reached through the tailnet cannot be pinged
+	if !config.DialsTailnet() {
+		route.latencyMu.Lock()
+		route.latency = 0
+		route.latencyMu.Unlock()
+		return
+	}
+
 	c, err := route.client.LocalClient()
 	route.latencyMu.Lock()
 	defer route.latencyMu.Unlock()
@@ -318,7 +613,7 @@ func (route *UDPRoute) measureLatency() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	ip, err := netip.ParseAddr(route.config.Machine.Address)
+	ip, err := netip.ParseAddr(machineHost(config, route.client))
 	if err != nil {
 		route.latency = -1
 		return
@@ -337,3 +632,44 @@ func (route *UDPRoute) Ping() time.Duration {
 	defer route.latencyMu.RUnlock()
 	return route.latency
 }
+
+// ActiveConnections returns the number of live client sessions
+func (route *UDPRoute) ActiveConnections() int64 {
+	timeout := route.sessionTimeout()
+	var count int64
+	route.sessions.Range(func(_, value any) bool {
+		if time.Since(value.(*udpSession).lastSeen.Load().(time.Time)) <= timeout {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// Connections lists the live client sessions
+func (route *UDPRoute) Connections() []Connection {
+	timeout := route.sessionTimeout()
+	connections := []Connection{}
+	route.sessions.Range(func(_, value any) bool {
+		session := value.(*udpSession)
+		if time.Since(session.lastSeen.Load().(time.Time)) <= timeout {
+			connections = append(connections, session.stats.connection())
+		}
+		return true
+	})
+	return connections
+}
+
+// CloseConnection drops a client session, replies are no longer forwarded to the
+// client until it sends another packet
+func (route *UDPRoute) CloseConnection(id string) bool {
+	found := false
+	route.sessions.Range(func(key, value any) bool {
+		if value.(*udpSession).stats.id == id {
+			found = route.removeSession(key)
+			return false
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,245 @@
+package router
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"warptail/pkg/utils"
+)
+
+const webSocketCheckInterval = time.Second
+
+var (
+	// Unmasked server frames, a ping with no payload and a 1001 "going away" close.
+	webSocketPingFrame      = []byte{0x89, 0x00}
+	webSocketGoingAwayFrame = []byte{0x88, 0x02, 0x03, 0xe9}
+)
+
+func isWebSocketRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// wsFrameTracker follows WebSocket frame boundaries in a byte stream so control
+// frames can be injected without corrupting a frame that is mid flight.
+type wsFrameTracker struct {
+	header    []byte
+	remaining uint64
+}
+
+func (t *wsFrameTracker) feed(p []byte) {
+	for len(p) > 0 {
+		if t.remaining > 0 {
+			n := min(uint64(len(p)), t.remaining)
+			t.remaining -= n
+			p = p[n:]
+			continue
+		}
+		t.header = append(t.header, p[0])
+		p = p[1:]
+		if size, ok := t.headerSize(); ok && len(t.header) == size {
+			t.remaining = t.payloadLength()
+			t.header = t.header[:0]
+		}
+	}
+}
+
+func (t *wsFrameTracker) headerSize() (int, bool) {
+	if len(t.header) < 2 {
+		return 0, false
+	}
+	size := 2
+	switch t.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if t.header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size, true
+}
+
+func (t *wsFrameTracker) payloadLength() uint64 {
+	switch length := t.header[1] & 0x7f; length {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(t.header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(t.header[2:10])
+	default:
+		return uint64(length)
+	}
+}
+
+func (t *wsFrameTracker) atBoundary() bool {
+	return t.remaining == 0 && len(t.header) == 0
+}
+
+// webSocketConn wraps the hijacked client side of a WebSocket so traffic is
+// counted as it flows and the socket can be pinged, limited and closed by the route.
+type webSocketConn struct {
+	net.Conn
+	route    *HTTPRoute
+	settings utils.WebSocketSettings
+	started  time.Time
+
+	mu     sync.Mutex // serialises writes to the client with frame tracking
+	frames wsFrameTracker
+
+	lastActive atomic.Int64
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+func newWebSocketConn(conn net.Conn, route *HTTPRoute, settings utils.WebSocketSettings) *webSocketConn {
+	ws := &webSocketConn{
+		Conn:     conn,
+		route:    route,
+		settings: settings,
+		started:  time.Now(),
+		done:     make(chan struct{}),
+	}
+	ws.touch()
+	return ws
+}
+
+func (ws *webSocketConn) touch() {
+	ws.lastActive.Store(time.Now().UnixNano())
+}
+
+func (ws *webSocketConn) idle() time.Duration {
+	return time.Since(time.Unix(0, ws.lastActive.Load()))
+}
+
+// Read counts data sent from the client to the backend.
+func (ws *webSocketConn) Read(p []byte) (int, error) {
+	n, err := ws.Conn.Read(p)
+	if n > 0 {
+		ws.touch()
+		ws.route.data.LogSent(uint64(n))
+	}
+	return n, err
+}
+
+// Write counts data received from the backend and tracks frame boundaries.
+func (ws *webSocketConn) Write(p []byte) (int, error) {
+	ws.mu.Lock()
+	n, err := ws.Conn.Write(p)
+	ws.frames.feed(p[:n])
+	ws.mu.Unlock()
+	if n > 0 {
+		ws.touch()
+		ws.route.data.LogRecived(uint64(n))
+	}
+	return n, err
+}
+
+func (ws *webSocketConn) CloseWrite() error {
+	if cw, ok := ws.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (ws *webSocketConn) Close() error {
+	var err error
+	ws.closeOnce.Do(func() {
+		close(ws.done)
+		ws.route.untrackWebSocket(ws)
+		err = ws.Conn.Close()
+	})
+	return err
+}
+
+// writeControl sends a control frame to the client if no data frame is in progress.
+func (ws *webSocketConn) writeControl(frame []byte) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if !ws.frames.atBoundary() {
+		return false
+	}
+	ws.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := ws.Conn.Write(frame)
+	ws.Conn.SetWriteDeadline(time.Time{})
+	return err == nil
+}
+
+// GoingAway tells the client the route is stopping before closing the socket.
+func (ws *webSocketConn) GoingAway() {
+	ws.writeControl(webSocketGoingAwayFrame)
+	ws.Close()
+}
+
+func (ws *webSocketConn) supervise() {
+	ticker := time.NewTicker(webSocketCheckInterval)
+	defer ticker.Stop()
+
+	maxDuration := time.Duration(ws.settings.MaxDuration) * time.Second
+	pingInterval := time.Duration(ws.settings.PingInterval) * time.Second
+	idleTimeout := time.Duration(ws.settings.IdleTimeout) * time.Second
+	lastPing := time.Now()
+
+	for {
+		select {
+		case <-ws.done:
+			return
+		case <-ticker.C:
+			if maxDuration > 0 && time.Since(ws.started) > maxDuration {
+				utils.Logger.Info("Closing websocket, max duration reached", "domain", ws.route.Config().Domain, "client", ws.RemoteAddr().String())
+				ws.GoingAway()
+				return
+			}
+			idle := ws.idle()
+			if idleTimeout > 0 && idle > idleTimeout {
+				utils.Logger.Info("Closing idle websocket", "domain", ws.route.Config().Domain, "client", ws.RemoteAddr().String())
+				ws.GoingAway()
+				return
+			}
+			if pingInterval > 0 && idle > pingInterval && time.Since(lastPing) > pingInterval {
+				if ws.writeControl(webSocketPingFrame) {
+					lastPing = time.Now()
+				}
+			}
+		}
+	}
+}
+
+func (route *HTTPRoute) webSocketSettings() utils.WebSocketSettings {
+	config := route.Config()
+	if config.ProxySettings != nil && config.ProxySettings.WebSocket != nil {
+		return *config.ProxySettings.WebSocket
+	}
+	return utils.WebSocketSettings{}
+}
+
+// trackWebSocket is used as the hijack hook for upgraded requests.
+func (route *HTTPRoute) trackWebSocket(conn net.Conn) net.Conn {
+	ws := newWebSocketConn(conn, route, route.webSocketSettings())
+	route.websockets.Store(ws, struct{}{})
+	route.websocketCount.Add(1)
+	go ws.supervise()
+	return ws
+}
+
+func (route *HTTPRoute) untrackWebSocket(ws *webSocketConn) {
+	if _, loaded := route.websockets.LoadAndDelete(ws); loaded {
+		route.websocketCount.Add(-1)
+	}
+}
+
+func (route *HTTPRoute) closeWebSockets() {
+	route.websockets.Range(func(key, _ any) bool {
+		key.(*webSocketConn).GoingAway()
+		return true
+	})
+}
+
+// ActiveWebSockets returns the number of open WebSocket connections on the route
+func (route *HTTPRoute) ActiveWebSockets() int64 {
+	return route.websocketCount.Load()
+}
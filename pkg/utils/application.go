@@ -2,18 +2,54 @@ package utils
 
 import (
 	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultReadHeaderTimeout = 10
+	DefaultIdleTimeout       = 120
 )
 
 type ApplicationConfig struct {
 	Port     int    `yaml:"port"`
 	SiteName string `yaml:"site_name,omitempty"`
 	SiteLogo string `yaml:"site_logo,omitempty"`
+
+	// Server timeouts are in seconds, read and write timeouts default to unlimited
+	// so long lived streams and websockets are not cut off.
+	ReadTimeout       int `yaml:"read_timeout,omitempty"`
+	ReadHeaderTimeout int `yaml:"read_header_timeout,omitempty"`
+	WriteTimeout      int `yaml:"write_timeout,omitempty"`
+	IdleTimeout       int `yaml:"idle_timeout,omitempty"`
+	MaxHeaderBytes    int `yaml:"max_header_bytes,omitempty"`
 }
 
 func (app *ApplicationConfig) GetHTTPAddr() string {
 	return fmt.Sprintf(":%d", app.Port)
 }
 
+// NewServer creates a http.Server for addr with the configured global timeouts applied
+func (app *ApplicationConfig) NewServer(addr string, handler http.Handler) *http.Server {
+	readHeaderTimeout := app.ReadHeaderTimeout
+	if readHeaderTimeout == 0 {
+		readHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	idleTimeout := app.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       time.Duration(app.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(readHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(app.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(idleTimeout) * time.Second,
+		MaxHeaderBytes:    app.MaxHeaderBytes,
+	}
+}
+
 // func (app *ApplicationConfig) GetSSLAddr() string {
 // 	return fmt.Sprintf(":%d", app.Acme.SslPort)
 // }
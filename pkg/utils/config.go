@@ -3,9 +3,13 @@ package utils
 import (
 	"context"
 	"crypto/md5"
+	"fmt"
 	"log"
 	"os"
 	"reflect"
+	"slices"
+	"strings"
+	"time"
 	"warptail/pkg/migrations"
 
 	"github.com/uptrace/bun"
@@ -14,12 +18,48 @@ import (
 )
 
 type TailscaleConfig struct {
-	AuthKey  string `yaml:"auth_key"`
-	Hostname string `yaml:"hostnmae"`
+	AuthKey      string   `yaml:"auth_key"`
+	Hostname     string   `yaml:"hostnmae"`
+	StateDir     string   `yaml:"state_dir,omitempty"`
+	Ephemeral    bool     `yaml:"ephemeral,omitempty"`
+	Tags         []string `yaml:"tags,omitempty"`
+	ClientSecret string   `yaml:"oauth_client_secret,omitempty"`
+	// KeyExpiryWarning is the number of days before the node key expires to start warning
+	KeyExpiryWarning int `yaml:"key_expiry_warning,omitempty"`
+}
+
+const defaultKeyExpiryWarning = 7
+
+// KeyExpiryWindow returns how long before the node key expires to start warning
+func (config TailscaleConfig) KeyExpiryWindow() time.Duration {
+	days := config.KeyExpiryWarning
+	if days <= 0 {
+		days = defaultKeyExpiryWarning
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func (config TailscaleConfig) validate() error {
+	if len(config.ClientSecret) > 0 && len(config.Tags) == 0 {
+		return fmt.Errorf("invalid tailscale config `oauth_client_secret` requires at least one tag in `tags`")
+	}
+	for _, tag := range config.Tags {
+		if !strings.HasPrefix(tag, "tag:") {
+			return fmt.Errorf("invalid tailscale config tag %s must start with `tag:`", tag)
+		}
+	}
+	return nil
+}
+
+// TailnetConfig is an additional tailnet connection, services select it by name
+type TailnetConfig struct {
+	Name            string `yaml:"name" json:"name"`
+	TailscaleConfig `yaml:",inline"`
 }
 
 type Config struct {
 	Tailscale          TailscaleConfig          `yaml:"tailscale"`
+	Tailnets           []TailnetConfig          `yaml:"tailnets,omitempty"`
 	Database           DatabaseConfig           `yaml:"database"`
 	Application        ApplicationConfig        `yaml:"application"`
 	Authentication     AuthenticationConfig     `yaml:"authentication"`
@@ -35,10 +75,16 @@ func (app *Config) UseHTTPS() bool {
 
 var ConfigPath = os.Getenv("CONFIG_PATH")
 
+// ArchivePath is the directory archives uploaded for file routes are stored in
+var ArchivePath = os.Getenv("ARCHIVE_PATH")
+
 func init() {
 	if len(ConfigPath) == 0 {
 		ConfigPath = "config.yaml"
 	}
+	if len(ArchivePath) == 0 {
+		ArchivePath = "archives"
+	}
 }
 
 func ConfigHash(path string) [16]byte {
@@ -84,10 +130,37 @@ func (config *Config) validate() error {
 		return err
 	}
 
+	if err := config.Tailscale.validate(); err != nil {
+		return err
+	}
+
+	tailnets := []string{"default"}
+	for _, tailnet := range config.Tailnets {
+		if len(tailnet.Name) == 0 {
+			return fmt.Errorf("invalid tailnet config missing `name`")
+		}
+		if slices.Contains(tailnets, tailnet.Name) {
+			return fmt.Errorf("invalid tailnet config %s name must be unique and not `default`", tailnet.Name)
+		}
+		if err := tailnet.validate(); err != nil {
+			return fmt.Errorf("tailnet %s: %w", tailnet.Name, err)
+		}
+		tailnets = append(tailnets, tailnet.Name)
+	}
+
 	for _, svc := range config.Services {
 		if err := svc.validate(); err != nil {
 			return err
 		}
+		if len(svc.Tailnet) > 0 && !slices.Contains(tailnets, svc.Tailnet) {
+			return fmt.Errorf("invalid config for service %s unknown `tailnet` %s", svc.Name, svc.Tailnet)
+		}
+		// passthrough connections are split off the ACME HTTPS listener, without it nothing receives them
+		for _, route := range svc.Routes {
+			if route.Type == TLS_PASSTHROUGH && (!config.UseHTTPS() || !IsEmptyStruct(config.Kubernetes)) {
+				return fmt.Errorf("invalid config for route %s `tls-passthrough` requires `acme` to be enabled outside kubernetes", svc.Name)
+			}
+		}
 	}
 	return nil
 }
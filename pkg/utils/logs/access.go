@@ -0,0 +1,138 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+	FormatTemplate = "template"
+)
+
+// AccessLogConfig controls how requests on a route are written to the access log.
+type AccessLogConfig struct {
+	Disabled     bool    `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+	SampleRate   float64 `yaml:"sample_rate,omitempty" json:"sample_rate,omitempty"`
+	Format       string  `yaml:"format,omitempty" json:"format,omitempty"`
+	Template     string  `yaml:"template,omitempty" json:"template,omitempty"`
+	SeparateFile bool    `yaml:"separate_file,omitempty" json:"separate_file,omitempty"`
+}
+
+// sampled reports whether a request should be logged given the sample rate,
+// a rate of 0 or 1 and above logs everything.
+func (cfg *AccessLogConfig) sampled() bool {
+	if cfg.SampleRate <= 0 || cfg.SampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < cfg.SampleRate
+}
+
+// AccessEntry is a single proxied request.
+type AccessEntry struct {
+	Time      time.Time     `json:"time"`
+	ClientIP  string        `json:"remote_addr"`
+	Host      string        `json:"host"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"protocol"`
+	Status    int           `json:"status"`
+	Size      int           `json:"bytes"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Upstream  string        `json:"upstream_addr,omitempty"`
+	Latency   time.Duration `json:"-"`
+	Service   string        `json:"service,omitempty"`
+	Route     string        `json:"route,omitempty"`
+	User      string        `json:"user,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+func (entry AccessEntry) latencyMs() string {
+	return strconv.FormatFloat(float64(entry.Latency.Microseconds())/1000, 'f', 3, 64)
+}
+
+// variable resolves template variables, unknown variables expand to "-"
+func (entry AccessEntry) variable(name string) string {
+	value := ""
+	switch name {
+	case "remote_addr":
+		value = entry.ClientIP
+	case "time":
+		value = entry.Time.Format(time.RFC3339)
+	case "host":
+		value = entry.Host
+	case "method":
+		value = entry.Method
+	case "uri":
+		value = entry.URI
+	case "protocol":
+		value = entry.Proto
+	case "status":
+		value = strconv.Itoa(entry.Status)
+	case "bytes":
+		value = strconv.Itoa(entry.Size)
+	case "referer":
+		value = entry.Referer
+	case "user_agent":
+		value = entry.UserAgent
+	case "upstream_addr":
+		value = entry.Upstream
+	case "latency":
+		value = entry.Latency.String()
+	case "latency_ms":
+		value = entry.latencyMs()
+	case "service":
+		value = entry.Service
+	case "route":
+		value = entry.Route
+	case "user":
+		value = entry.User
+	case "request_id":
+		value = entry.RequestID
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// Format renders the entry in the configured format, defaulting to combined
+func (entry AccessEntry) Format(cfg *AccessLogConfig) string {
+	format := FormatCombined
+	if cfg != nil && cfg.Format != "" {
+		format = cfg.Format
+	}
+	switch format {
+	case FormatJSON:
+		data, err := json.Marshal(struct {
+			AccessEntry
+			LatencyMs float64 `json:"latency_ms"`
+		}{entry, float64(entry.Latency.Microseconds()) / 1000})
+		if err == nil {
+			return string(data)
+		}
+	case FormatTemplate:
+		if cfg.Template != "" {
+			return os.Expand(cfg.Template, entry.variable)
+		}
+	}
+	return fmt.Sprintf(
+		`%s - %s [%s] "%s %s %s" %d %d "%s" "%s"`,
+		entry.ClientIP,
+		entry.variable("user"),
+		entry.Time.Format("02/Jan/2006:15:04:05"),
+		entry.Method,
+		entry.URI,
+		entry.Proto,
+		entry.Status,
+		entry.Size,
+		entry.Referer,
+		entry.UserAgent,
+	)
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -42,9 +44,22 @@ func (lrw *HttpResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Flush forwards to the underlying writer so streamed responses are not held back.
+func (lrw *HttpResponseWriter) Flush() {
+	http.NewResponseController(lrw.ResponseWriter).Flush()
+}
+
+func (lrw *HttpResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 type LoggingResponseWriter struct {
+	path      string
 	accessLog *DualWriter
 	errorLog  *DualWriter
+
+	mu           sync.Mutex
+	serviceFiles map[string]*os.File
 }
 
 func NewAccessLogWriter(path string) (*LoggingResponseWriter, error) {
@@ -62,8 +77,10 @@ func NewAccessLogWriter(path string) (*LoggingResponseWriter, error) {
 	}
 
 	lrw := &LoggingResponseWriter{
-		accessLog: accessFile,
-		errorLog:  errorFile,
+		path:         path,
+		accessLog:    accessFile,
+		errorLog:     errorFile,
+		serviceFiles: make(map[string]*os.File),
 	}
 	return lrw, nil
 }
@@ -93,23 +110,57 @@ func getClientIP(r *http.Request) string {
 	return ip
 }
 
+// NewAccessEntry builds an access entry from the request and its response
+func NewAccessEntry(r *http.Request, start time.Time, statusCode int, size int) AccessEntry {
+	return AccessEntry{
+		Time:      start,
+		ClientIP:  getClientIP(r),
+		Host:      r.Host,
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Proto:     r.Proto,
+		Status:    statusCode,
+		Size:      size,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		Latency:   time.Since(start),
+	}
+}
+
 func (lrw *LoggingResponseWriter) LogRequest(r *http.Request, start time.Time, statusCode int, size int) {
-	logLine := fmt.Sprintf(
-		`%s - - [%s] "%s %s %s" %d %d "%s" "%s"`,
-		getClientIP(r),
-		start.Format("02/Jan/2006:15:04:05"),
-		r.Method,
-		r.RequestURI,
-		r.Proto,
-		statusCode,
-		size,
-		r.Referer(),
-		r.UserAgent(),
-	)
-	if statusCode >= 500 {
-		lrw.LogError(r, fmt.Errorf("server error: %d", statusCode))
-	}
-	lrw.accessLog.WriteString(logLine + "\n")
+	lrw.LogAccess(r, NewAccessEntry(r, start, statusCode, size), nil)
+}
+
+// LogAccess writes the entry to the access log using the route configuration,
+// server errors are always written to the error log even when access logging is off.
+func (lrw *LoggingResponseWriter) LogAccess(r *http.Request, entry AccessEntry, cfg *AccessLogConfig) {
+	if entry.Status >= 500 {
+		lrw.LogError(r, fmt.Errorf("server error: %d", entry.Status))
+	}
+	if cfg != nil && (cfg.Disabled || !cfg.sampled()) {
+		return
+	}
+	logLine := entry.Format(cfg) + "\n"
+	lrw.accessLog.WriteString(logLine)
+	if cfg != nil && cfg.SeparateFile && entry.Service != "" {
+		lrw.writeServiceLog(entry.Service, logLine)
+	}
+}
+
+func (lrw *LoggingResponseWriter) writeServiceLog(service string, line string) {
+	lrw.mu.Lock()
+	defer lrw.mu.Unlock()
+	file, ok := lrw.serviceFiles[service]
+	if !ok {
+		var err error
+		path := filepath.Join(lrw.path, filepath.Base(service)+".access.log")
+		file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		lrw.serviceFiles[service] = file
+	}
+	file.WriteString(line)
 }
 
 func (lrw *LoggingResponseWriter) GetLogs(logType string) ([]string, error) {
@@ -146,5 +197,11 @@ func (lrw *LoggingResponseWriter) Close() error {
 	if err := lrw.errorLog.Close(); err != nil {
 		return fmt.Errorf("error closing error log file: %v", err)
 	}
+	lrw.mu.Lock()
+	defer lrw.mu.Unlock()
+	for service, file := range lrw.serviceFiles {
+		file.Close()
+		delete(lrw.serviceFiles, service)
+	}
 	return nil
 }
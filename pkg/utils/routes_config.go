@@ -3,7 +3,13 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/netip"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"warptail/pkg/utils/logs"
 )
 
 type RouteType string
@@ -13,11 +19,52 @@ const (
 	UDP   = RouteType("udp")
 	HTTP  = RouteType("http")
 	HTTPS = RouteType("https")
+	FILES = RouteType("files")
+
+	TLS_PASSTHROUGH = RouteType("tls-passthrough")
+)
+
+type IPVersion string
+
+const (
+	DualStack = IPVersion("dual")
+	IPv4      = IPVersion("ipv4")
+	IPv6      = IPVersion("ipv6")
+)
+
+// RouteDirection is the side of warptail a route listens on
+type RouteDirection string
+
+const (
+	// Forward routes listen on the host and forward into the tailnet
+	Forward = RouteDirection("forward")
+	// Reverse routes listen on the tailnet node and forward to the LAN or internet
+	Reverse = RouteDirection("reverse")
+)
+
+// ExposeMode is where an https route is published
+type ExposeMode string
+
+const (
+	// ExposePublic serves the route on the public listeners only
+	ExposePublic = ExposeMode("public")
+	// ExposeFunnel publishes the route to the internet through Tailscale Funnel only
+	ExposeFunnel = ExposeMode("funnel")
+	// ExposePublicFunnel serves the route on the public listeners and through Tailscale Funnel
+	ExposePublicFunnel = ExposeMode("public+funnel")
+	// ExposeTailnet serves the route on the tailnet node with its tailnet certificate
+	ExposeTailnet = ExposeMode("tailnet")
 )
 
+// FunnelPorts are the tailnet node ports Tailscale Funnel can publish
+var FunnelPorts = []int{443, 8443, 10000}
+
+const MaxPortRange = 1024
+
 type ServiceConfig struct {
 	Name    string        `yaml:"name" json:"name"`
 	Enabled bool          `yaml:"enabled" json:"enabled"`
+	Tailnet string        `yaml:"tailnet,omitempty" json:"tailnet,omitempty"`
 	Routes  []RouteConfig `yaml:"routes" json:"routes"`
 }
 
@@ -35,24 +82,128 @@ type ProxyHeaders struct {
 	Set    map[string]string `yaml:"set,omitempty" json:"set,omitempty"`
 }
 
+type WebSocketSettings struct {
+	MaxDuration  int `yaml:"max_duration,omitempty" json:"max_duration,omitempty"`
+	PingInterval int `yaml:"ping_interval,omitempty" json:"ping_interval,omitempty"`
+	IdleTimeout  int `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+}
+
 type ProxySettings struct {
-	Timeout         int           `yaml:"timeout,omitempty" json:"timeout,omitempty"`
-	RetryAttempts   int           `yaml:"retry_attempts,omitempty" json:"retry_attempts,omitempty"`
-	BufferRequests  bool          `yaml:"buffer_requests,omitempty" json:"buffer_requests,omitempty"`
-	PreserveHost    bool          `yaml:"preserve_host,omitempty" json:"preserve_host,omitempty"`
-	FollowRedirects bool          `yaml:"follow_redirects,omitempty" json:"follow_redirects,omitempty"`
-	CustomHeaders   *ProxyHeaders `yaml:"custom_headers,omitempty" json:"custom_headers,omitempty"`
-	Rules           []ProxyRule   `yaml:"rules,omitempty" json:"rules,omitempty"`
+	Timeout               int                `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	MaxRequestBody        int64              `yaml:"max_request_body,omitempty" json:"max_request_body,omitempty"`
+	ResponseHeaderTimeout int                `yaml:"response_header_timeout,omitempty" json:"response_header_timeout,omitempty"`
+	IdleTimeout           int                `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+	RetryAttempts         int                `yaml:"retry_attempts,omitempty" json:"retry_attempts,omitempty"`
+	BufferRequests        bool               `yaml:"buffer_requests,omitempty" json:"buffer_requests,omitempty"`
+	Streaming             bool               `yaml:"streaming,omitempty" json:"streaming,omitempty"`
+	FlushInterval         int                `yaml:"flush_interval,omitempty" json:"flush_interval,omitempty"`
+	PreserveHost          bool               `yaml:"preserve_host,omitempty" json:"preserve_host,omitempty"`
+	FollowRedirects       bool               `yaml:"follow_redirects,omitempty" json:"follow_redirects,omitempty"`
+	CustomHeaders         *ProxyHeaders      `yaml:"custom_headers,omitempty" json:"custom_headers,omitempty"`
+	Rules                 []ProxyRule        `yaml:"rules,omitempty" json:"rules,omitempty"`
+	WebSocket             *WebSocketSettings `yaml:"websocket,omitempty" json:"websocket,omitempty"`
+}
+
+type FileSettings struct {
+	Root             string   `yaml:"root,omitempty" json:"root,omitempty"`
+	Archive          string   `yaml:"archive,omitempty" json:"archive,omitempty"`
+	IndexFiles       []string `yaml:"index_files,omitempty" json:"index_files,omitempty"`
+	SPA              bool     `yaml:"spa,omitempty" json:"spa,omitempty"`
+	DirectoryListing bool     `yaml:"directory_listing,omitempty" json:"directory_listing,omitempty"`
+	CacheMaxAge      int      `yaml:"cache_max_age,omitempty" json:"cache_max_age,omitempty"`
+}
+
+type ProxyProtocolSettings struct {
+	Send           int      `yaml:"send,omitempty" json:"send,omitempty"`
+	Accept         bool     `yaml:"accept,omitempty" json:"accept,omitempty"`
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty"`
+}
+
+type UDPSettings struct {
+	SessionTimeout int  `yaml:"session_timeout,omitempty" json:"session_timeout,omitempty"`
+	MaxSessions    int  `yaml:"max_sessions,omitempty" json:"max_sessions,omitempty"`
+	BufferSize     int  `yaml:"buffer_size,omitempty" json:"buffer_size,omitempty"`
+	SessionSockets bool `yaml:"session_sockets,omitempty" json:"session_sockets,omitempty"`
+}
+
+// DialerType selects how a route reaches its backend
+type DialerType string
+
+const (
+	// TailscaleDialer dials the backend through the tsnet node
+	TailscaleDialer = DialerType("tailscale")
+	// DirectDialer dials the backend from the host network
+	DirectDialer = DialerType("direct")
+	// SOCKS5Dialer dials the backend through a SOCKS5 proxy
+	SOCKS5Dialer = DialerType("socks5")
+	// HTTPConnectDialer dials the backend through an HTTP CONNECT proxy
+	HTTPConnectDialer = DialerType("http-connect")
+)
+
+type DialerSettings struct {
+	Type DialerType `yaml:"type" json:"type"`
+	// Proxy is the host:port of the SOCKS5 or HTTP CONNECT proxy
+	Proxy    string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+	// ViaTailnet reaches the proxy through the tsnet node instead of the host network
+	ViaTailnet bool `yaml:"via_tailnet,omitempty" json:"via_tailnet,omitempty"`
+}
+
+// TailscaleIdentitySettings use the tailnet identity of clients connecting over Tailscale
+type TailscaleIdentitySettings struct {
+	Headers      bool     `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Authenticate bool     `yaml:"authenticate,omitempty" json:"authenticate,omitempty"`
+	Users        []string `yaml:"users,omitempty" json:"users,omitempty"`
+}
+
+// Allows reports whether a tailnet user login or node tag may use a private route,
+// every tailnet identity is allowed when no users are listed.
+func (settings *TailscaleIdentitySettings) Allows(login string, tags []string) bool {
+	if settings == nil || len(settings.Users) == 0 {
+		return true
+	}
+	for _, user := range settings.Users {
+		if strings.EqualFold(user, login) || slices.Contains(tags, user) {
+			return true
+		}
+	}
+	return false
+}
+
+type TLSSettings struct {
+	CertFile     string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile      string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	ClientCAFile string `yaml:"client_ca_file,omitempty" json:"client_ca_file,omitempty"`
+	VerifyClient bool   `yaml:"verify_client,omitempty" json:"verify_client,omitempty"`
+}
+
+// UseACME reports whether the certificate should be issued by the certificate manager
+func (tls *TLSSettings) UseACME() bool {
+	return len(tls.CertFile) == 0
 }
 
 type RouteConfig struct {
-	Type          RouteType      `yaml:"type" json:"type"`
-	Private       bool           `yaml:"private" json:"private,omitempty"`
-	BotProtect    bool           `yaml:"bot_protect" json:"bot_protect,omitempty"`
-	Domain        string         `yaml:"domain,omitempty" json:"domain,omitempty"`
-	Port          int            `yaml:"port,omitempty" json:"port,omitempty"`
-	Machine       Machine        `yaml:"machine" json:"machine"`
-	ProxySettings *ProxySettings `yaml:"proxy_settings,omitempty" json:"proxy_settings,omitempty"`
+	Type              RouteType                  `yaml:"type" json:"type"`
+	Direction         RouteDirection             `yaml:"direction,omitempty" json:"direction,omitempty"`
+	Expose            ExposeMode                 `yaml:"expose,omitempty" json:"expose,omitempty"`
+	Private           bool                       `yaml:"private" json:"private,omitempty"`
+	BotProtect        bool                       `yaml:"bot_protect" json:"bot_protect,omitempty"`
+	Domain            string                     `yaml:"domain,omitempty" json:"domain,omitempty"`
+	Port              int                        `yaml:"port,omitempty" json:"port,omitempty"`
+	PortEnd           int                        `yaml:"port_end,omitempty" json:"port_end,omitempty"`
+	ListenAddress     string                     `yaml:"listen_address,omitempty" json:"listen_address,omitempty"`
+	IPVersion         IPVersion                  `yaml:"ip_version,omitempty" json:"ip_version,omitempty"`
+	DrainTimeout      int                        `yaml:"drain_timeout,omitempty" json:"drain_timeout,omitempty"`
+	Machine           Machine                    `yaml:"machine" json:"machine"`
+	ProxySettings     *ProxySettings             `yaml:"proxy_settings,omitempty" json:"proxy_settings,omitempty"`
+	FileSettings      *FileSettings              `yaml:"file_settings,omitempty" json:"file_settings,omitempty"`
+	AccessLog         *logs.AccessLogConfig      `yaml:"access_log,omitempty" json:"access_log,omitempty"`
+	ProxyProtocol     *ProxyProtocolSettings     `yaml:"proxy_protocol,omitempty" json:"proxy_protocol,omitempty"`
+	TLS               *TLSSettings               `yaml:"tls,omitempty" json:"tls,omitempty"`
+	UDP               *UDPSettings               `yaml:"udp,omitempty" json:"udp,omitempty"`
+	TailscaleIdentity *TailscaleIdentitySettings `yaml:"tailscale_identity,omitempty" json:"tailscale_identity,omitempty"`
+	Dialer            *DialerSettings            `yaml:"dialer,omitempty" json:"dialer,omitempty"`
 }
 
 type Machine struct {
@@ -62,28 +213,121 @@ type Machine struct {
 }
 
 func RouteComparison(v1, v2 RouteConfig) bool {
-	if v1.Type != v2.Type {
+	if v1.Type != v2.Type || v1.IsReverse() != v2.IsReverse() {
 		return false
 	}
-	if (v1.Machine.Address) != v2.Machine.Address {
+	if v1.Type == FILES {
+		return v1.Domain == v2.Domain
+	}
+	if (v1.Machine.Address) != v2.Machine.Address || v1.Machine.NodeName != v2.Machine.NodeName {
 		return false
 	}
 	if (v1.Machine.Port) != v2.Machine.Port {
 		return false
 	}
-	switch v1.Type {
-	case HTTP, HTTPS:
+	switch {
+	case v1.Type == HTTP && v1.IsReverse():
+		if v1.Port != v2.Port {
+			return false
+		}
+	case v1.Type == HTTP, v1.Type == HTTPS, v1.Type == TLS_PASSTHROUGH:
 		if v1.Domain != v2.Domain {
 			return false
 		}
-	case TCP, UDP:
-		if v1.Port != v2.Port {
+	case v1.Type == TCP, v1.Type == UDP:
+		if v1.Port != v2.Port || v1.PortEnd != v2.PortEnd {
 			return false
 		}
 	}
 	return true
 }
 
+// IsReverse reports whether the route listens on the tailnet node
+func (route RouteConfig) IsReverse() bool {
+	return route.Direction == Reverse
+}
+
+// DialerType returns how the route reaches its backend, forward routes dial
+// through the tailnet and reverse routes from the host unless a dialer is set
+func (route RouteConfig) DialerType() DialerType {
+	if route.Dialer != nil && len(route.Dialer.Type) > 0 {
+		return route.Dialer.Type
+	}
+	if route.IsReverse() {
+		return DirectDialer
+	}
+	return TailscaleDialer
+}
+
+// DialsTailnet reports whether the backend is reached through the tsnet node
+func (route RouteConfig) DialsTailnet() bool {
+	return route.DialerType() == TailscaleDialer
+}
+
+// IsPublic reports whether the route is served on the public listeners
+func (route RouteConfig) IsPublic() bool {
+	return route.Expose == "" || route.Expose == ExposePublic || route.Expose == ExposePublicFunnel
+}
+
+// NeedsTailnet reports whether the route only works while its tailnet is running,
+// it dials the backend, listens or is exposed on the tailnet node
+func (route RouteConfig) NeedsTailnet() bool {
+	if route.IsReverse() || route.IsTailnetExposed() {
+		return true
+	}
+	return route.Type != FILES && route.DialsTailnet()
+}
+
+// IsTailnetExposed reports whether an https route is served on the tailnet node
+func (route RouteConfig) IsTailnetExposed() bool {
+	return route.Type == HTTPS && (route.Expose == ExposeFunnel || route.Expose == ExposePublicFunnel || route.Expose == ExposeTailnet)
+}
+
+// ExposePort returns the tailnet node port an exposed route is served on
+func (route RouteConfig) ExposePort() int {
+	if route.Port == 0 {
+		return 443
+	}
+	return route.Port
+}
+
+// IsPortRange reports whether a TCP or UDP route listens on a range of ports
+func (route RouteConfig) IsPortRange() bool {
+	return route.PortEnd > route.Port
+}
+
+// Ports returns every port the route listens on
+func (route RouteConfig) Ports() []int {
+	if !route.IsPortRange() {
+		return []int{route.Port}
+	}
+	ports := make([]int, 0, route.PortEnd-route.Port+1)
+	for port := route.Port; port <= route.PortEnd; port++ {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// ListenNetwork returns the network to listen on, e.g. tcp, tcp4 or tcp6
+func (route RouteConfig) ListenNetwork() string {
+	network := string(route.Type)
+	if route.Type != UDP {
+		network = string(TCP)
+	}
+	switch route.IPVersion {
+	case IPv4:
+		return network + "4"
+	case IPv6:
+		return network + "6"
+	}
+	return network
+}
+
+// ListenAddr returns the local address the route binds to
+func (route RouteConfig) ListenAddr() string {
+	return net.JoinHostPort(route.ListenAddress, strconv.Itoa(route.Port))
+}
+
 func ValidatePort(port int) error {
 	if port < 0 || port > 65535 {
 		return errors.New("invalid port: must be between 0 and 65535")
@@ -131,18 +375,37 @@ func ValidateDomain(domain string) error {
 
 func (cfg ServiceConfig) validate() error {
 	for _, route := range cfg.Routes {
-		if len(route.Machine.Address) == 0 {
-			return fmt.Errorf("invalid config for route %s missing tailscale `machine.address`", cfg.Name)
-		} else if err := ValidateHostname(route.Machine.Address); err != nil {
-			return fmt.Errorf("invalid config for route %s `machine.address` %w", cfg.Name, err)
+		if err := route.validateIdentity(cfg.Name); err != nil {
+			return err
+		}
+		if route.Type == FILES {
+			if err := route.validateFiles(cfg.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := route.validateMachine(cfg.Name); err != nil {
+			return err
 		}
 		if (route.Machine.Port) == 0 {
 			return fmt.Errorf("invalid config for route %s missing tailscale `machine.port`", cfg.Name)
 		} else if err := ValidatePort(int(route.Machine.Port)); err != nil {
 			return fmt.Errorf("invalid config for route %s `machine.port` %w", cfg.Name, err)
 		}
+		if err := route.validateDirection(cfg.Name); err != nil {
+			return err
+		}
+		if err := route.validateExpose(cfg.Name); err != nil {
+			return err
+		}
+		if err := route.validateDialer(cfg.Name); err != nil {
+			return err
+		}
 		switch route.Type {
-		case HTTP, HTTPS:
+		case HTTP, HTTPS, TLS_PASSTHROUGH:
+			if route.IsReverse() {
+				break
+			}
 			if len(route.Domain) == 0 {
 				return fmt.Errorf("invalid config for route %s missing `domain`", cfg.Name)
 			} else if err := ValidateDomain(route.Domain); err != nil {
@@ -155,8 +418,239 @@ func (cfg ServiceConfig) validate() error {
 			} else if err := ValidatePort(int(route.Port)); err != nil {
 				return fmt.Errorf("invalid config for route %s `port` %w", cfg.Name, err)
 			}
+			if err := route.validateListen(cfg.Name); err != nil {
+				return err
+			}
+			if err := route.validateProxyProtocol(cfg.Name); err != nil {
+				return err
+			}
+			if err := route.validateTLS(cfg.Name); err != nil {
+				return err
+			}
+			if err := route.validateUDP(cfg.Name); err != nil {
+				return err
+			}
 		default:
-			return fmt.Errorf("invalid config for route %s missing or invalid `type` choose between [http,https,tcp,udp]", cfg.Name)
+			return fmt.Errorf("invalid config for route %s missing or invalid `type` choose between [http,https,tcp,udp,files,tls-passthrough]", cfg.Name)
+		}
+	}
+	return nil
+}
+
+func (route RouteConfig) validateFiles(name string) error {
+	if len(route.Domain) == 0 {
+		return fmt.Errorf("invalid config for route %s missing `domain`", name)
+	} else if err := ValidateDomain(route.Domain); err != nil {
+		return fmt.Errorf("invalid config for route %s `domian` %w", name, err)
+	}
+	if route.FileSettings == nil || (len(route.FileSettings.Root) == 0 && len(route.FileSettings.Archive) == 0) {
+		return fmt.Errorf("invalid config for route %s missing `file_settings.root` or `file_settings.archive`", name)
+	}
+	if len(route.FileSettings.Root) > 0 && len(route.FileSettings.Archive) > 0 {
+		return fmt.Errorf("invalid config for route %s only one of `file_settings.root` or `file_settings.archive` can be set", name)
+	}
+	return nil
+}
+
+func (route RouteConfig) validateProxyProtocol(name string) error {
+	settings := route.ProxyProtocol
+	if settings == nil {
+		return nil
+	}
+	switch settings.Send {
+	case 0, 2:
+	case 1:
+		if route.Type == UDP {
+			return fmt.Errorf("invalid config for route %s `proxy_protocol.send` udp routes only support version 2", name)
+		}
+	default:
+		return fmt.Errorf("invalid config for route %s `proxy_protocol.send` must be 1 or 2", name)
+	}
+	if settings.Accept && len(settings.TrustedProxies) == 0 {
+		return fmt.Errorf("invalid config for route %s `proxy_protocol.accept` requires `proxy_protocol.trusted_proxies`", name)
+	}
+	for _, proxy := range settings.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(proxy); err != nil {
+			return fmt.Errorf("invalid config for route %s `proxy_protocol.trusted_proxies` %s is not an ip or cidr", name, proxy)
+		}
+	}
+	return nil
+}
+
+func (route RouteConfig) validateTLS(name string) error {
+	settings := route.TLS
+	if settings == nil {
+		return nil
+	}
+	if route.Type != TCP {
+		return fmt.Errorf("invalid config for route %s `tls` is only supported on tcp routes", name)
+	}
+	if (len(settings.CertFile) == 0) != (len(settings.KeyFile) == 0) {
+		return fmt.Errorf("invalid config for route %s `tls.cert_file` and `tls.key_file` must be set together", name)
+	}
+	if settings.UseACME() {
+		if len(route.Domain) == 0 {
+			return fmt.Errorf("invalid config for route %s `tls` without a certificate requires `domain`", name)
+		} else if err := ValidateDomain(route.Domain); err != nil {
+			return fmt.Errorf("invalid config for route %s `domian` %w", name, err)
+		}
+	}
+	if settings.VerifyClient && len(settings.ClientCAFile) == 0 {
+		return fmt.Errorf("invalid config for route %s `tls.verify_client` requires `tls.client_ca_file`", name)
+	}
+	return nil
+}
+
+func (route RouteConfig) validateIdentity(name string) error {
+	if route.TailscaleIdentity == nil {
+		return nil
+	}
+	if route.Type != HTTP && route.Type != HTTPS && route.Type != FILES {
+		return fmt.Errorf("invalid config for route %s `tailscale_identity` is only supported on http, https and files routes", name)
+	}
+	return nil
+}
+
+// validateMachine checks the backend is set by address or, for routes into the
+// tailnet, by node name resolved at runtime
+func (route RouteConfig) validateMachine(name string) error {
+	if len(route.Machine.NodeName) > 0 && !route.IsReverse() {
+		if err := ValidateHostname(route.Machine.NodeName); err != nil {
+			return fmt.Errorf("invalid config for route %s `machine.node` %w", name, err)
+		}
+		if len(route.Machine.Address) == 0 {
+			return nil
+		}
+	} else if len(route.Machine.Address) == 0 {
+		return fmt.Errorf("invalid config for route %s missing tailscale `machine.address` or `machine.node`", name)
+	}
+	// IP addresses are accepted as is, those outside the tailnet range are reached
+	// through the peer advertising a subnet route for them
+	if _, err := netip.ParseAddr(route.Machine.Address); err == nil {
+		return nil
+	}
+	if err := ValidateHostname(route.Machine.Address); err != nil {
+		return fmt.Errorf("invalid config for route %s `machine.address` %w", name, err)
+	}
+	return nil
+}
+
+func (route RouteConfig) validateDirection(name string) error {
+	switch route.Direction {
+	case "", Forward:
+		return nil
+	case Reverse:
+	default:
+		return fmt.Errorf("invalid config for route %s `direction` choose between [forward,reverse]", name)
+	}
+	if route.Type != TCP && route.Type != UDP && route.Type != HTTP {
+		return fmt.Errorf("invalid config for route %s reverse routes must be of type [tcp,udp,http]", name)
+	}
+	if route.Port == 0 {
+		return fmt.Errorf("invalid config for route %s reverse routes require the tailnet `port`", name)
+	} else if err := ValidatePort(route.Port); err != nil {
+		return fmt.Errorf("invalid config for route %s `port` %w", name, err)
+	}
+	if len(route.ListenAddress) > 0 {
+		return fmt.Errorf("invalid config for route %s `listen_address` is not supported on reverse routes", name)
+	}
+	return nil
+}
+
+func (route RouteConfig) validateExpose(name string) error {
+	switch route.Expose {
+	case "", ExposePublic:
+		return nil
+	case ExposeFunnel, ExposePublicFunnel, ExposeTailnet:
+	default:
+		return fmt.Errorf("invalid config for route %s `expose` choose between [public,funnel,public+funnel,tailnet]", name)
+	}
+	if route.Type != HTTPS {
+		return fmt.Errorf("invalid config for route %s `expose` is only supported on https routes", name)
+	}
+	if err := ValidatePort(route.Port); err != nil {
+		return fmt.Errorf("invalid config for route %s `port` %w", name, err)
+	}
+	if route.Expose != ExposeTailnet && !slices.Contains(FunnelPorts, route.ExposePort()) {
+		return fmt.Errorf("invalid config for route %s funnel routes must use `port` 443, 8443 or 10000", name)
+	}
+	return nil
+}
+
+func (route RouteConfig) validateDialer(name string) error {
+	settings := route.Dialer
+	if settings == nil {
+		return nil
+	}
+	switch route.DialerType() {
+	case TailscaleDialer:
+		if route.IsReverse() {
+			return fmt.Errorf("invalid config for route %s reverse routes cannot use the `tailscale` dialer", name)
+		}
+	case DirectDialer:
+	case SOCKS5Dialer, HTTPConnectDialer:
+		if route.Type == UDP {
+			return fmt.Errorf("invalid config for route %s udp routes only support the [tailscale,direct] dialers", name)
+		}
+		if _, port, err := net.SplitHostPort(settings.Proxy); err != nil || len(port) == 0 {
+			return fmt.Errorf("invalid config for route %s `dialer.proxy` must be host:port", name)
+		}
+	default:
+		return fmt.Errorf("invalid config for route %s `dialer.type` choose between [tailscale,direct,socks5,http-connect]", name)
+	}
+	return nil
+}
+
+func (route RouteConfig) validateUDP(name string) error {
+	settings := route.UDP
+	if settings == nil {
+		return nil
+	}
+	if route.Type != UDP {
+		return fmt.Errorf("invalid config for route %s `udp` is only supported on udp routes", name)
+	}
+	if settings.SessionTimeout < 0 {
+		return fmt.Errorf("invalid config for route %s `udp.session_timeout` must not be negative", name)
+	}
+	if settings.MaxSessions < 0 {
+		return fmt.Errorf("invalid config for route %s `udp.max_sessions` must not be negative", name)
+	}
+	if settings.BufferSize < 0 || settings.BufferSize > 65535 {
+		return fmt.Errorf("invalid config for route %s `udp.buffer_size` must be between 0 and 65535", name)
+	}
+	return nil
+}
+
+func (route RouteConfig) validateListen(name string) error {
+	if route.PortEnd != 0 {
+		if err := ValidatePort(route.PortEnd); err != nil {
+			return fmt.Errorf("invalid config for route %s `port_end` %w", name, err)
+		}
+		if route.PortEnd <= route.Port {
+			return fmt.Errorf("invalid config for route %s `port_end` must be greater than `port`", name)
+		}
+		if route.PortEnd-route.Port >= MaxPortRange {
+			return fmt.Errorf("invalid config for route %s port range must not exceed %d ports", name, MaxPortRange)
+		}
+		if int(route.Machine.Port)+route.PortEnd-route.Port > 65535 {
+			return fmt.Errorf("invalid config for route %s `machine.port` range exceeds 65535", name)
+		}
+	}
+	switch route.IPVersion {
+	case "", DualStack, IPv4, IPv6:
+	default:
+		return fmt.Errorf("invalid config for route %s `ip_version` choose between [dual,ipv4,ipv6]", name)
+	}
+	if len(route.ListenAddress) > 0 {
+		addr, err := netip.ParseAddr(route.ListenAddress)
+		if err != nil {
+			return fmt.Errorf("invalid config for route %s `listen_address` %w", name, err)
+		}
+		if (route.IPVersion == IPv4 && !addr.Is4()) || (route.IPVersion == IPv6 && !addr.Is6()) {
+			return fmt.Errorf("invalid config for route %s `listen_address` does not match `ip_version`", name)
 		}
 	}
 	return nil